@@ -0,0 +1,51 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"context"
+	"sync"
+)
+
+// DoAll executes the requests in rs, running up to concurrency of them at
+// once, and returns the error from each in the same order as rs.  Results
+// are found in the RequestResponses themselves, as with Do.  Requests without
+// a Context of their own are given ctx; once ctx is done, requests not yet
+// started fail with its error.  A concurrency less than 1 means 1.
+func (c *Client) DoAll(ctx context.Context, rs []*RequestResponse, concurrency int) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(rs) {
+		concurrency = len(rs)
+	}
+	errs := make([]error, len(rs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for n := 0; n < concurrency; n++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				r := rs[i]
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				if r.Context == nil {
+					r.Context = ctx
+				}
+				_, errs[i] = c.Do(r)
+			}
+		}()
+	}
+	for i := range rs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"context"
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoAll(t *testing.T) {
+	var running, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(req.URL.Query().Get("n")))
+	}))
+	defer srv.Close()
+	c := New()
+	var rs []*RequestResponse
+	for i := 0; i < 10; i++ {
+		rs = append(rs, &RequestResponse{
+			Url:    srv.URL,
+			Method: GET,
+			Params: map[string]string{"n": strconv.Itoa(i)},
+			Result: new(int),
+		})
+	}
+	errs := c.DoAll(context.Background(), rs, 3)
+	assert.Equal(t, len(errs), 10)
+	for i, r := range rs {
+		assert.Equal(t, errs[i], nil)
+		assert.Equal(t, *r.Result.(*int), i)
+	}
+	assert.T(t, atomic.LoadInt32(&peak) <= 3)
+}
+
+func TestDoAllCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	c := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rs := []*RequestResponse{
+		{Url: srv.URL, Method: GET},
+		{Url: srv.URL, Method: GET},
+	}
+	errs := c.DoAll(ctx, rs, 2)
+	for _, err := range errs {
+		assert.Equal(t, err, context.Canceled)
+	}
+	assert.Equal(t, rs[0].Status, 0)
+}
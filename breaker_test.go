@@ -0,0 +1,65 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing int32 = 1
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			JsonError(w, "Down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	cb := &CircuitBreaker{
+		Threshold: 2,
+		Cooldown:  50 * time.Millisecond,
+	}
+	client := New()
+	client.CircuitBreaker = cb
+	get := func() error {
+		r := RequestResponse{
+			Url:    srv.URL,
+			Method: GET,
+			Params: fooMap,
+		}
+		_, err := client.Do(&r)
+		return err
+	}
+	assert.Equal(t, get(), nil)
+	assert.Equal(t, cb.State(), CircuitClosed)
+	assert.Equal(t, get(), nil)
+	assert.Equal(t, cb.State(), CircuitOpen)
+	assert.Equal(t, get(), ErrCircuitOpen)
+	assert.Equal(t, hits, int32(2))
+	//
+	// After the cooldown a failed probe reopens the breaker...
+	//
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, cb.State(), CircuitHalfOpen)
+	assert.Equal(t, get(), nil)
+	assert.Equal(t, cb.State(), CircuitOpen)
+	assert.Equal(t, hits, int32(3))
+	//
+	// ...and a successful one closes it.
+	//
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, get(), nil)
+	assert.Equal(t, cb.State(), CircuitClosed)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"net/http"
+)
+
+// A RequestBuilder composes a RequestResponse through chained method calls,
+// then executes it with Send.  For example:
+//
+//	var res Foo
+//	rr, err := client.Request().Get().URL("http://foo.com/bar").
+//		Param("k", "v").Header("X-Foo", "baz").Into(&res).Send()
+type RequestBuilder struct {
+	c  *Client
+	rr RequestResponse
+}
+
+// Request returns a RequestBuilder for a GET request using c.
+func (c *Client) Request() *RequestBuilder {
+	return &RequestBuilder{
+		c:  c,
+		rr: RequestResponse{Method: GET},
+	}
+}
+
+// Method sets the HTTP method of the request.
+func (b *RequestBuilder) Method(m Method) *RequestBuilder {
+	b.rr.Method = m
+	return b
+}
+
+// Get makes the request a GET.
+func (b *RequestBuilder) Get() *RequestBuilder { return b.Method(GET) }
+
+// Post makes the request a POST.
+func (b *RequestBuilder) Post() *RequestBuilder { return b.Method(POST) }
+
+// Put makes the request a PUT.
+func (b *RequestBuilder) Put() *RequestBuilder { return b.Method(PUT) }
+
+// Patch makes the request a PATCH.
+func (b *RequestBuilder) Patch() *RequestBuilder { return b.Method(PATCH) }
+
+// Delete makes the request a DELETE.
+func (b *RequestBuilder) Delete() *RequestBuilder { return b.Method(DELETE) }
+
+// URL sets the URL of the request.
+func (b *RequestBuilder) URL(url string) *RequestBuilder {
+	b.rr.Url = url
+	return b
+}
+
+// Param adds a query parameter to the request.
+func (b *RequestBuilder) Param(key, value string) *RequestBuilder {
+	if b.rr.Params == nil {
+		b.rr.Params = make(map[string]string)
+	}
+	b.rr.Params[key] = value
+	return b
+}
+
+// Header sets a header on the request.
+func (b *RequestBuilder) Header(key, value string) *RequestBuilder {
+	if b.rr.Headers == nil {
+		b.rr.Headers = &http.Header{}
+	}
+	b.rr.Headers.Set(key, value)
+	return b
+}
+
+// Bearer authenticates the request with a bearer token.
+func (b *RequestBuilder) Bearer(token string) *RequestBuilder {
+	b.rr.BearerToken = token
+	return b
+}
+
+// Data sets the data to be encoded as the request body.
+func (b *RequestBuilder) Data(data interface{}) *RequestBuilder {
+	b.rr.Data = data
+	return b
+}
+
+// Into sets the value into which a successful response is unmarshalled.
+func (b *RequestBuilder) Into(result interface{}) *RequestBuilder {
+	b.rr.Result = result
+	return b
+}
+
+// ErrorInto sets the value into which an error response is unmarshalled.
+func (b *RequestBuilder) ErrorInto(e interface{}) *RequestBuilder {
+	b.rr.Error = e
+	return b
+}
+
+// Send executes the request, returning the completed RequestResponse.
+func (b *RequestBuilder) Send() (*RequestResponse, error) {
+	_, err := b.c.Do(&b.rr)
+	return &b.rr, err
+}
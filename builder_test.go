@@ -0,0 +1,34 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Foo") != "baz" || req.Header.Get("Authorization") != "Bearer tok" {
+			JsonError(w, "Bad headers", http.StatusBadRequest)
+			return
+		}
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	var res structType
+	e := new(errorStruct)
+	rr, err := New().Request().Get().URL(srv.URL).
+		Param("foo", "bar").Header("X-Foo", "baz").Bearer("tok").
+		Into(&res).ErrorInto(e).Send()
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, rr.Status, 200, e.Message)
+	assert.Equal(t, res, barStruct)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A Cache stores responses to GET requests, so they can be revalidated with
+// If-None-Match rather than fetched again.  Keys are request URLs; a response
+// with a Vary header is only revalidated by requests which match it in those
+// headers.  Responses to requests with Stream or Output set are not cached,
+// nor are bodies longer than the client's MaxResponseBytes.  A Cache used by
+// a Client must be safe for concurrent use.
+type Cache interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, e *CacheEntry)
+}
+
+// A CacheEntry is a response stored in a Cache.
+type CacheEntry struct {
+	ETag   string
+	Header http.Header
+	Body   []byte      // Raw response body, before any decompression
+	Vary   http.Header // The request's values of the headers named by Vary
+}
+
+// matches reports whether a request with headers h may be served e, as it has
+// the same values of the headers named by e's Vary header.
+func (e *CacheEntry) matches(h http.Header) bool {
+	for k, vv := range e.Vary {
+		if strings.Join(h.Values(k), ", ") != strings.Join(vv, ", ") {
+			return false
+		}
+	}
+	return true
+}
+
+// varyHeaders returns the values in req, the request headers, of the headers
+// named by the Vary header in resp, and whether resp may be cached: not if it
+// varies by "*".
+func varyHeaders(req, resp http.Header) (http.Header, bool) {
+	var vary http.Header
+	for _, v := range resp.Values("Vary") {
+		for _, k := range strings.Split(v, ",") {
+			k = strings.TrimSpace(k)
+			switch k {
+			case "":
+				continue
+			case "*":
+				return nil, false
+			}
+			if vary == nil {
+				vary = make(http.Header)
+			}
+			vary[http.CanonicalHeaderKey(k)] = append([]string(nil), req.Values(k)...)
+		}
+	}
+	return vary, true
+}
+
+// NewMemoryCache returns a Cache which stores responses in memory.
+func NewMemoryCache() Cache {
+	return &memoryCache{entries: make(map[string]*CacheEntry)}
+}
+
+type memoryCache struct {
+	mu      sync.RWMutex
+	entries map[string]*CacheEntry
+}
+
+func (m *memoryCache) Get(key string) (*CacheEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.entries[key]
+	return e, ok
+}
+
+func (m *memoryCache) Set(key string, e *CacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = e
+}
+
+// noStore reports whether h forbids caching with Cache-Control: no-store.
+func noStore(h http.Header) bool {
+	for _, v := range h["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheKey returns the key under which the response to r, sent to url, is
+// cached, or "" if it should not be cached.
+func (c *Client) cacheKey(r *RequestResponse, url string) string {
+	if c.Cache == nil || r.Method != GET || r.Stream || r.Output != nil {
+		return ""
+	}
+	if r.Headers != nil && noStore(*r.Headers) {
+		return ""
+	}
+	return url
+}
+
+// cacheResponse serves a 304 Not Modified response to r from entry, setting
+// r.FromCache, or stores a fresh response carrying an ETag under key.  Only a
+// request which was sent with entry's ETag in If-None-Match, and which
+// matches its Vary header, is served from it; sent holds the headers sent.
+// It returns the response to be used in place of resp.
+func (c *Client) cacheResponse(r *RequestResponse, key string, entry *CacheEntry, sent http.Header, resp *http.Response) (*http.Response, error) {
+	vary, cacheable := varyHeaders(sent, resp.Header)
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil &&
+		sent.Get("If-None-Match") == entry.ETag && entry.matches(sent):
+		resp.Body.Close()
+		r.FromCache = true
+		cached := *resp
+		cached.StatusCode = http.StatusOK
+		cached.Status = "200 OK"
+		cached.Header = entry.Header.Clone()
+		cached.Body = ioutil.NopCloser(bytes.NewReader(entry.Body))
+		return &cached, nil
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "" && !noStore(resp.Header) && cacheable:
+		rd := io.Reader(resp.Body)
+		if c.MaxResponseBytes > 0 {
+			rd = io.LimitReader(rd, c.MaxResponseBytes+1)
+		}
+		body, err := ioutil.ReadAll(rd)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if c.MaxResponseBytes > 0 && int64(len(body)) > c.MaxResponseBytes {
+			// Too large to cache; the body is read on as it would have been
+			resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+			return resp, nil
+		}
+		resp.Body.Close()
+		c.Cache.Set(key, &CacheEntry{
+			ETag:   resp.Header.Get("ETag"),
+			Header: resp.Header.Clone(),
+			Body:   body,
+			Vary:   vary,
+		})
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return resp, nil
+}
+
+// A readCloser reads from Reader, and closes Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
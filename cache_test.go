@@ -0,0 +1,202 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"bytes"
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// etagServer serves barStruct with an ETag, counting the number of full
+// responses sent in hits.
+func etagServer(cacheControl string, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(hits, 1)
+		w.Header().Set("ETag", `"v1"`)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		HandleGet(w, req)
+	}))
+}
+
+func TestCache(t *testing.T) {
+	var hits int32
+	srv := etagServer("", &hits)
+	defer srv.Close()
+	client := New()
+	client.Cache = NewMemoryCache()
+	for i := 0; i < 3; i++ {
+		r := RequestResponse{
+			Url:    srv.URL,
+			Method: GET,
+			Params: fooMap,
+			Result: new(structType),
+		}
+		status, err := client.Do(&r)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, status, 200)
+		assert.Equal(t, r.Result, &barStruct)
+		assert.Equal(t, r.FromCache, i > 0)
+	}
+	assert.Equal(t, hits, int32(1))
+}
+
+func TestCacheNoStore(t *testing.T) {
+	var hits int32
+	srv := etagServer("private, no-store", &hits)
+	defer srv.Close()
+	client := New()
+	client.Cache = NewMemoryCache()
+	for i := 0; i < 2; i++ {
+		r := RequestResponse{
+			Url:    srv.URL,
+			Method: GET,
+			Params: fooMap,
+			Result: new(structType),
+		}
+		status, err := client.Do(&r)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, status, 200)
+	}
+	assert.Equal(t, hits, int32(2))
+}
+
+func TestCacheOwnIfNoneMatch(t *testing.T) {
+	// The server answers any If-None-Match with 304
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	client := New()
+	client.Cache = NewMemoryCache()
+	r := RequestResponse{Url: srv.URL, Method: GET, Params: fooMap, Result: new(structType)}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = RequestResponse{Url: srv.URL, Method: GET, Params: fooMap, IfNoneMatch: `"v1"`, Result: new(structType)}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.FromCache, true)
+	assert.Equal(t, r.Result, &barStruct)
+	//
+	// A 304 to some other ETag is the caller's to handle
+	//
+	r = RequestResponse{Url: srv.URL, Method: GET, Params: fooMap, IfNoneMatch: `"v2"`, Result: new(structType)}
+	status, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 304)
+	assert.Equal(t, r.FromCache, false)
+	assert.Equal(t, r.Result, new(structType))
+}
+
+func TestCacheVary(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		etag := `"` + req.Header.Get("X-Lang") + `"`
+		w.Header().Set("Vary", "X-Lang")
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(etag))
+	}))
+	defer srv.Close()
+	client := New()
+	client.Cache = NewMemoryCache()
+	for i, lang := range []string{"en", "en", "fr", "fr"} {
+		r := RequestResponse{
+			Url:     srv.URL,
+			Method:  GET,
+			Headers: &http.Header{"X-Lang": {lang}},
+			Result:  new(string),
+		}
+		_, err := client.Do(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, *r.Result.(*string), lang)
+		assert.Equal(t, r.FromCache, i%2 == 1)
+	}
+	assert.Equal(t, hits, int32(2))
+}
+
+// countingCache is a Cache counting the entries stored in it.
+type countingCache struct {
+	Cache
+	sets int32
+}
+
+func (c *countingCache) Set(key string, e *CacheEntry) {
+	atomic.AddInt32(&c.sets, 1)
+	c.Cache.Set(key, e)
+}
+
+func TestCacheLimits(t *testing.T) {
+	big := strings.Repeat("a", 100000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`"` + big + `"`))
+	}))
+	defer srv.Close()
+	cache := &countingCache{Cache: NewMemoryCache()}
+	client := New()
+	client.Cache = cache
+	client.MaxResponseBytes = 100
+	r := RequestResponse{Url: srv.URL, Method: GET}
+	_, err := client.Do(&r)
+	assert.Equal(t, err, ErrResponseTooLarge)
+	assert.Equal(t, cache.sets, int32(0))
+	client.MaxResponseBytes = 0
+	r = RequestResponse{Url: srv.URL, Method: GET, Result: new(string), Stream: true}
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *r.Result.(*string), big)
+	var out bytes.Buffer
+	r = RequestResponse{Url: srv.URL, Method: GET, Output: &out}
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out.Len(), len(big)+2)
+	assert.Equal(t, cache.sets, int32(0))
+	r = RequestResponse{Url: srv.URL, Method: GET}
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cache.sets, int32(1))
+}
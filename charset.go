@@ -0,0 +1,100 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// responseCharset returns the lower case charset given by the Content-Type
+// of resp, or "" if there is none.
+func responseCharset(resp *http.Response) string {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(params["charset"])
+}
+
+// isLatin1 reports whether cs names the ISO-8859-1 charset.
+func isLatin1(cs string) bool {
+	switch cs {
+	case "iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "l1":
+		return true
+	}
+	return false
+}
+
+// transcoder returns a function converting the body of resp to UTF-8, or nil
+// if it is UTF-8 already or its charset is not one the client can convert.
+// ISO-8859-1 is converted by the client itself; other charsets need a
+// CharsetReader.
+func (c *Client) transcoder(resp *http.Response) func(io.Reader) (io.Reader, error) {
+	cs := responseCharset(resp)
+	switch {
+	case cs == "" || cs == "utf-8" || cs == "utf8" || cs == "us-ascii":
+		return nil
+	case isLatin1(cs):
+		return func(rd io.Reader) (io.Reader, error) {
+			return &latin1Reader{r: rd}, nil
+		}
+	case c.CharsetReader != nil:
+		return func(rd io.Reader) (io.Reader, error) {
+			return c.CharsetReader(cs, rd)
+		}
+	}
+	return nil
+}
+
+// transcode returns a reader of the body of resp, read from rd, converted
+// to UTF-8 where possible.
+func (c *Client) transcode(resp *http.Response, rd io.Reader) (io.Reader, error) {
+	f := c.transcoder(resp)
+	if f == nil {
+		return rd, nil
+	}
+	return f(rd)
+}
+
+// bodyEncoding returns the Encoding with which to decode the body of resp
+// once transcoded, or nil if it should not be decoded.  XML documents
+// declaring another encoding in their prolog are taken to be UTF-8 after
+// transcoding.
+func (c *Client) bodyEncoding(resp *http.Response) Encoding {
+	enc := c.responseEncoding(resp)
+	if enc == XML && c.transcoder(resp) != nil {
+		return xmlEncoding{utf8: true}
+	}
+	return enc
+}
+
+// latin1Reader converts ISO-8859-1 text read from r to UTF-8.
+type latin1Reader struct {
+	r       io.Reader
+	buf     [512]byte
+	pending []byte // Converted text not yet returned
+	err     error  // Error from r, returned once pending is drained
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	for len(l.pending) == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		var n int
+		n, l.err = l.r.Read(l.buf[:])
+		for _, b := range l.buf[:n] {
+			l.pending = utf8.AppendRune(l.pending, rune(b))
+		}
+	}
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
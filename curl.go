@@ -0,0 +1,77 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// secretHeaders are masked in curl commands unless asked otherwise.
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// AsCurl returns a curl command reproducing the request which Do would send
+// for r, with credentials masked, for use when reporting problems.
+func (c *Client) AsCurl(r *RequestResponse) (string, error) {
+	req, err := c.BuildRequest(r)
+	if err != nil {
+		return "", err
+	}
+	return CurlCommand(req, false)
+}
+
+// CurlCommand returns a curl command reproducing req.  Unless showSecrets is
+// set, the values of Authorization, Proxy-Authorization and Cookie headers
+// are replaced by "***".  The body is read via req.GetBody if possible, and
+// otherwise consumed.
+func CurlCommand(req *http.Request, showSecrets bool) (string, error) {
+	parts := []string{"curl", "-X", shellQuote(req.Method)}
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range req.Header[k] {
+			if !showSecrets {
+				for _, s := range secretHeaders {
+					if http.CanonicalHeaderKey(k) == s {
+						v = "***"
+					}
+				}
+			}
+			parts = append(parts, "-H", shellQuote(k+": "+v))
+		}
+	}
+	var body io.Reader = req.Body
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		body = rc
+	}
+	if body != nil && body != http.NoBody {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return "", err
+		}
+		if len(data) > 0 {
+			parts = append(parts, "--data-binary", shellQuote(string(data)))
+		}
+	}
+	parts = append(parts, shellQuote(req.URL.String()))
+	return strings.Join(parts, " "), nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAsCurl(t *testing.T) {
+	client := New()
+	client.UserAgent = "test"
+	r := RequestResponse{
+		Url:         "http://foo.com/users",
+		Method:      POST,
+		Params:      fooMap,
+		BearerToken: "s3cret",
+		Data:        map[string]string{"name": "O'Brien"},
+	}
+	cmd, err := client.AsCurl(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cmd, `curl -X 'POST' -H 'Accept: application/json' -H 'Authorization: ***' `+
+		`-H 'Content-Type: application/json' -H 'User-Agent: test' `+
+		`--data-binary '{"name":"O'\''Brien"}' 'http://foo.com/users?foo=bar'`)
+	req, err := client.BuildRequest(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err = CurlCommand(req, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.T(t, strings.Contains(cmd, `'Authorization: Bearer s3cret'`), cmd)
+	//
+	// GetBody leaves the request body unread
+	//
+	req, _ = http.NewRequest("GET", "http://foo.com/", strings.NewReader("body"))
+	CurlCommand(req, false)
+	cmd, _ = CurlCommand(req, false)
+	assert.Equal(t, cmd, `curl -X 'GET' --data-binary 'body' 'http://foo.com/'`)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// A digestChallenge is a WWW-Authenticate: Digest challenge, as per RFC 7616.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string // Upper case; "" means MD5
+	qop       bool   // Whether qop=auth is offered
+}
+
+// parseDigestChallenge returns the Digest challenge among the
+// WWW-Authenticate headers in h, if there is one the client can answer.
+func parseDigestChallenge(h http.Header) (*digestChallenge, bool) {
+	for _, v := range h.Values("WWW-Authenticate") {
+		if len(v) < 7 || !strings.EqualFold(v[:7], "Digest ") {
+			continue
+		}
+		ch := new(digestChallenge)
+		s := v[7:]
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if s == "" {
+				break
+			}
+			var name, value string
+			name, value, s = parseParam(s)
+			if strings.ContainsAny(name, " \t") {
+				// Start of another challenge
+				break
+			}
+			switch strings.ToLower(name) {
+			case "realm":
+				ch.realm = value
+			case "nonce":
+				ch.nonce = value
+			case "opaque":
+				ch.opaque = value
+			case "algorithm":
+				ch.algorithm = strings.ToUpper(value)
+			case "qop":
+				for _, q := range strings.Split(value, ",") {
+					if strings.TrimSpace(q) == "auth" {
+						ch.qop = true
+					}
+				}
+			}
+		}
+		if ch.nonce != "" && ch.newHash() != nil {
+			return ch, true
+		}
+	}
+	return nil, false
+}
+
+// newHash returns the hash function of the challenge's algorithm, or nil if
+// it is not supported.
+func (ch *digestChallenge) newHash() func() hash.Hash {
+	switch strings.TrimSuffix(ch.algorithm, "-SESS") {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// authorization returns the Authorization header answering the challenge for
+// a request with the given method and URI.
+func (ch *digestChallenge) authorization(username, password, method, uri string) (string, error) {
+	newHash := ch.newHash()
+	h := func(s string) string {
+		d := newHash()
+		io.WriteString(d, s)
+		return hex.EncodeToString(d.Sum(nil))
+	}
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(b)
+	const nc = "00000001"
+	ha1 := h(username + ":" + ch.realm + ":" + password)
+	if strings.HasSuffix(ch.algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + ch.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+	var response string
+	if ch.qop {
+		response = h(ha1 + ":" + ch.nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+	} else {
+		response = h(ha1 + ":" + ch.nonce + ":" + ha2)
+	}
+	q := func(s string) string {
+		return `"` + quoteEscaper.Replace(s) + `"`
+	}
+	parts := []string{
+		"username=" + q(username),
+		"realm=" + q(ch.realm),
+		"nonce=" + q(ch.nonce),
+		"uri=" + q(uri),
+		"response=" + q(response),
+	}
+	if ch.algorithm != "" {
+		parts = append(parts, "algorithm="+ch.algorithm)
+	}
+	if ch.qop {
+		parts = append(parts, "qop=auth", "nc="+nc, "cnonce="+q(cnonce))
+	}
+	if ch.opaque != "" {
+		parts = append(parts, "opaque="+q(ch.opaque))
+	}
+	return "Digest " + strings.Join(parts, ", "), nil
+}
+
+// digestAuth answers a Digest challenge in resp, the response to req, by
+// sending req again with the credentials in r.Userinfo.  If resp carries no
+// challenge which can be answered, it is returned as it is.
+func (c *Client) digestAuth(hc *http.Client, r *RequestResponse, req *http.Request, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode != http.StatusUnauthorized || r.Userinfo == nil {
+		return resp, nil
+	}
+	ch, ok := parseDigestChallenge(resp.Header)
+	if !ok || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+	pwd, _ := r.Userinfo.Password()
+	auth, err := ch.authorization(r.Userinfo.Username(), pwd, req.Method, req.URL.RequestURI())
+	if err != nil {
+		return resp, nil
+	}
+	req2 := req.Clone(req.Context())
+	if req.GetBody != nil {
+		req2.Body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	req2.Header.Set("Authorization", auth)
+	return c.roundTrip(hc, req2)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleDigest requires Digest authentication as jtkirk, and echoes the
+// request body.
+func HandleDigest(w http.ResponseWriter, req *http.Request) {
+	const realm, nonce = "enterprise", "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		w.Header().Add("WWW-Authenticate", `Basic realm="enterprise"`)
+		w.Header().Add("WWW-Authenticate", `Digest realm="`+realm+`", qop="auth,auth-int", nonce="`+nonce+`", opaque="5ccc"`)
+		JsonError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	p := map[string]string{}
+	s := auth[7:]
+	for s != "" {
+		var name, value string
+		name, value, s = parseParam(strings.TrimLeft(s, ", "))
+		p[name] = value
+	}
+	ha1 := md5hex("jtkirk:" + realm + ":Beam me up")
+	ha2 := md5hex(req.Method + ":" + req.URL.RequestURI())
+	want := md5hex(ha1 + ":" + nonce + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+	if p["username"] != "jtkirk" || p["uri"] != req.URL.RequestURI() || p["qop"] != "auth" ||
+		p["opaque"] != "5ccc" || p["response"] != want {
+		JsonError(w, "Bad credentials", http.StatusForbidden)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func TestDigestAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleDigest))
+	defer srv.Close()
+	client := New()
+	var res structType
+	r := RequestResponse{
+		Url:        srv.URL + "/ship",
+		Method:     POST,
+		Params:     fooMap,
+		Userinfo:   url.UserPassword("jtkirk", "Beam me up"),
+		AuthScheme: DigestAuth,
+		Data:       &fooStruct,
+		Result:     &res,
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200, r.RawText)
+	assert.Equal(t, res, fooStruct)
+	//
+	// Wrong credentials
+	//
+	r.Userinfo = url.UserPassword("jtkirk", "Beam me down")
+	status, _ = client.Do(&r)
+	assert.Equal(t, status, 403)
+	//
+	// Without DigestAuth the challenge isn't answered
+	//
+	client.UnsafeBasicAuth = true
+	r.AuthScheme = BasicAuth
+	status, _ = client.Do(&r)
+	assert.Equal(t, status, 401)
+}
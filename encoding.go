@@ -0,0 +1,378 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// An Encoding serializes request bodies and deserializes response bodies.
+type Encoding interface {
+	ContentType() string // Value for Content-Type and Accept headers
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// A StreamDecoder is an Encoding which can decode directly from a reader.
+// Encodings which are not StreamDecoders are buffered before decoding, even
+// when RequestResponse.Stream is set.
+type StreamDecoder interface {
+	Decode(r io.Reader, v interface{}) error
+}
+
+var (
+	JSON Encoding = JSONEncoding{}
+	XML  Encoding = xmlEncoding{}
+)
+
+// JSONEncoding is the JSON Encoding.  Its zero value, JSON, encodes requests
+// as compactly as json.Marshal, and decodes responses as json.Unmarshal.
+type JSONEncoding struct {
+	NoEscapeHTML bool   // Don't escape <, > and & in strings
+	Indent       string // If not empty, indent request bodies by this much per level
+	UseNumber    bool   // Decode numbers into interface{} values as json.Numbers, not float64s
+	//
+	// If DisallowUnknownFields is set, decoding an object with a field
+	// that the destination struct lacks is an error.
+	//
+	DisallowUnknownFields bool
+}
+
+func (JSONEncoding) ContentType() string {
+	return "application/json"
+}
+
+func (e JSONEncoding) Marshal(v interface{}) ([]byte, error) {
+	if !e.NoEscapeHTML && e.Indent == "" {
+		return json.Marshal(v)
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(!e.NoEscapeHTML)
+	enc.SetIndent("", e.Indent)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return copyBytes(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
+
+func (e JSONEncoding) Unmarshal(data []byte, v interface{}) error {
+	if !e.UseNumber && !e.DisallowUnknownFields {
+		return json.Unmarshal(data, v)
+	}
+	d := e.decoder(bytes.NewReader(data))
+	err := d.Decode(v)
+	if err != nil {
+		return err
+	}
+	// As json.Unmarshal, reject anything after the value
+	if _, err := d.Token(); err != io.EOF {
+		return errors.New("Invalid data after top-level JSON value")
+	}
+	return nil
+}
+
+func (e JSONEncoding) Decode(r io.Reader, v interface{}) error {
+	return e.decoder(r).Decode(v)
+}
+
+// decoder returns a json.Decoder reading from r, configured as per e.
+func (e JSONEncoding) decoder(r io.Reader) *json.Decoder {
+	d := json.NewDecoder(r)
+	if e.UseNumber {
+		d.UseNumber()
+	}
+	if e.DisallowUnknownFields {
+		d.DisallowUnknownFields()
+	}
+	return d
+}
+
+type xmlEncoding struct {
+	utf8 bool // Documents are UTF-8, whatever encoding they declare
+}
+
+func (xmlEncoding) ContentType() string {
+	return "application/xml"
+}
+
+func (xmlEncoding) Marshal(v interface{}) ([]byte, error) {
+	return xml.Marshal(v)
+}
+
+func (e xmlEncoding) Unmarshal(data []byte, v interface{}) error {
+	return e.Decode(bytes.NewReader(data), v)
+}
+
+func (e xmlEncoding) Decode(r io.Reader, v interface{}) error {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(cs string, input io.Reader) (io.Reader, error) {
+		switch {
+		case e.utf8:
+			return input, nil
+		case isLatin1(strings.ToLower(cs)):
+			return &latin1Reader{r: input}, nil
+		}
+		return nil, errors.New("Unsupported XML encoding " + strconv.Quote(cs))
+	}
+	return d.Decode(v)
+}
+
+// A MediaTyper is an Encoding which also decodes responses of media types
+// other than its ContentType, e.g. aliases used by some servers.  Responses
+// are decoded with the Encoding whose type matches their Content-Type.
+type MediaTyper interface {
+	MediaTypes() []string // Further media types decoded, without parameters
+}
+
+// NewEncoding returns an Encoding with the given content type, which
+// serializes with marshal and deserializes with unmarshal.  Responses whose
+// Content-Type is contentType or one of aliases are decoded with it.
+// Response bodies are passed to unmarshal as the value held in Result or
+// Error - the pointer supplied by the caller - rather than a pointer to the
+// field itself.  If the field is nil, the body is left in RawText, and a body
+// which doesn't fit is an error, rather than being decoded into Raw.
+func NewEncoding(contentType string, marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error, aliases ...string) Encoding {
+	return &customEncoding{contentType, marshal, unmarshal, aliases}
+}
+
+type customEncoding struct {
+	contentType string
+	marshal     func(v interface{}) ([]byte, error)
+	unmarshal   func(data []byte, v interface{}) error
+	aliases     []string
+}
+
+func (e *customEncoding) ContentType() string {
+	return e.contentType
+}
+
+func (e *customEncoding) MediaTypes() []string {
+	return e.aliases
+}
+
+func (e *customEncoding) Marshal(v interface{}) ([]byte, error) {
+	return e.marshal(v)
+}
+
+func (e *customEncoding) Unmarshal(data []byte, v interface{}) error {
+	if p, ok := v.(*interface{}); ok {
+		if *p == nil {
+			// Nothing to decode into
+			return nil
+		}
+		v = *p
+	}
+	return e.unmarshal(data, v)
+}
+
+// ProtobufContentType is the content type of Protocol Buffers bodies.
+const ProtobufContentType = "application/x-protobuf"
+
+// NewProtobufEncoding returns an Encoding for Protocol Buffers, using the
+// given functions, so that this package needn't depend on a protobuf
+// library.  It also decodes responses labelled application/protobuf or
+// application/vnd.google.protobuf; for others, such as
+// application/octet-stream, use NewEncoding with aliases.  With
+// google.golang.org/protobuf:
+//
+//	NewProtobufEncoding(
+//		func(v interface{}) ([]byte, error) { return proto.Marshal(v.(proto.Message)) },
+//		func(b []byte, v interface{}) error { return proto.Unmarshal(b, v.(proto.Message)) },
+//	)
+func NewProtobufEncoding(marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) Encoding {
+	return NewEncoding(ProtobufContentType, marshal, unmarshal, "application/protobuf", "application/vnd.google.protobuf")
+}
+
+// MsgpackContentType is the content type of MessagePack bodies.
+const MsgpackContentType = "application/msgpack"
+
+// NewMsgpackEncoding returns an Encoding for MessagePack, using the given
+// functions, e.g. NewMsgpackEncoding(msgpack.Marshal, msgpack.Unmarshal)
+// with github.com/vmihailenco/msgpack.  It also decodes responses labelled
+// application/x-msgpack or application/vnd.msgpack; for others, use
+// NewEncoding with aliases.
+func NewMsgpackEncoding(marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) Encoding {
+	return NewEncoding(MsgpackContentType, marshal, unmarshal, "application/x-msgpack", "application/vnd.msgpack")
+}
+
+// funcEncoding is a JSON Encoding using a client's Marshal and Unmarshal
+// funcs, where set.
+type funcEncoding struct {
+	Encoding
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+func (e funcEncoding) Marshal(v interface{}) ([]byte, error) {
+	if e.marshal == nil {
+		return e.Encoding.Marshal(v)
+	}
+	return e.marshal(v)
+}
+
+func (e funcEncoding) Unmarshal(data []byte, v interface{}) error {
+	if e.unmarshal == nil {
+		return e.Encoding.Unmarshal(data, v)
+	}
+	return e.unmarshal(data, v)
+}
+
+// isJSON reports whether enc is a JSON Encoding.
+func isJSON(enc Encoding) bool {
+	if f, ok := enc.(funcEncoding); ok {
+		enc = f.Encoding
+	}
+	_, ok := enc.(JSONEncoding)
+	return ok
+}
+
+// retainsData reports whether enc may keep references to the data it
+// unmarshals, as anything but the built-in JSON and XML decoding may.
+func retainsData(enc Encoding) bool {
+	if f, ok := enc.(funcEncoding); ok {
+		if f.unmarshal != nil {
+			return true
+		}
+		enc = f.Encoding
+	}
+	switch enc.(type) {
+	case nil, JSONEncoding, xmlEncoding:
+		return false
+	}
+	return true
+}
+
+// withFuncs returns enc, using the client's Marshal and Unmarshal funcs if
+// it is JSON and they are set, and its JSON decoding options.
+func (c *Client) withFuncs(enc Encoding) Encoding {
+	if je, ok := enc.(JSONEncoding); ok && c.UseNumber {
+		je.UseNumber = true
+		enc = je
+	}
+	if (c.Marshal == nil && c.Unmarshal == nil) || !isJSON(enc) {
+		return enc
+	}
+	return funcEncoding{enc, c.Marshal, c.Unmarshal}
+}
+
+// encoding returns the Encoding used by c.
+func (c *Client) encoding() Encoding {
+	if c.Encoding == nil {
+		return c.withFuncs(JSON)
+	}
+	return c.withFuncs(c.Encoding)
+}
+
+// responseEncoding returns the Encoding with which to decode the body of
+// resp, or nil if its Content-Type is not one the client can decode.  The
+// choice is made by Content-Type, so the client decodes whichever type the
+// server chose from its Accept header: the client's Encoding if it has that
+// type, or one of its MediaTypes, or else the built-in JSON or XML encoding.
+// The client's Encoding is assumed if no Content-Type is given, and always
+// used if the client has ForceEncoding set.
+func (c *Client) responseEncoding(resp *http.Response) Encoding {
+	ct := resp.Header.Get("Content-Type")
+	if ct == "" || c.ForceEncoding {
+		return c.encoding()
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case c.Encoding != nil && hasType(c.Encoding, mt):
+		return c.encoding()
+	case hasType(JSON, mt):
+		return c.withFuncs(JSON)
+	case hasType(XML, mt):
+		return XML
+	}
+	return nil
+}
+
+// hasType reports whether enc decodes the media type mt.
+func hasType(enc Encoding, mt string) bool {
+	if want, _, _ := mime.ParseMediaType(enc.ContentType()); mt == want {
+		return true
+	}
+	if f, ok := enc.(funcEncoding); ok {
+		enc = f.Encoding
+	}
+	if m, ok := enc.(MediaTyper); ok {
+		for _, t := range m.MediaTypes() {
+			if strings.EqualFold(t, mt) {
+				return true
+			}
+		}
+	}
+	if _, ok := enc.(xmlEncoding); ok {
+		return mt == "application/xml" || mt == "text/xml" || strings.HasSuffix(mt, "+xml")
+	}
+	return isJSON(enc) && (mt == "application/json" || strings.HasSuffix(mt, "+json"))
+}
+
+// resultEncoding returns enc, which decodes responses into Result, with the
+// client's DisallowUnknownFields applied if it is JSON.
+func (c *Client) resultEncoding(enc Encoding) Encoding {
+	if !c.DisallowUnknownFields {
+		return enc
+	}
+	switch e := enc.(type) {
+	case JSONEncoding:
+		e.DisallowUnknownFields = true
+		return e
+	case funcEncoding:
+		e.Encoding = c.resultEncoding(e.Encoding)
+		return e
+	}
+	return enc
+}
+
+// unsupportedType returns the error for a successful response to r, resp,
+// whose Content-Type cannot be decoded: none if there is no Result to decode
+// it into.
+func unsupportedType(r *RequestResponse, resp *http.Response) error {
+	if r.Result == nil {
+		return nil
+	}
+	return errors.New("Unsupported Content-Type " + strconv.Quote(resp.Header.Get("Content-Type")))
+}
+
+// decodeStream decodes the successful response resp, whose body is read from
+// rd, into r.Result.
+func (c *Client) decodeStream(r *RequestResponse, resp *http.Response, rd io.Reader) error {
+	enc := c.bodyEncoding(resp)
+	if enc == nil {
+		return unsupportedType(r, resp)
+	}
+	enc = c.resultEncoding(enc)
+	sd, ok := enc.(StreamDecoder)
+	if !ok {
+		data, err := ioutil.ReadAll(rd)
+		if err != nil || len(data) == 0 {
+			return err
+		}
+		return enc.Unmarshal(data, target(&r.Result))
+	}
+	err := sd.Decode(rd, target(&r.Result))
+	if err == io.EOF {
+		// Empty body
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,612 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/bmizerany/assert"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func HandleXml(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/xml" ||
+		req.Header.Get("Accept") != "application/xml" {
+		http.Error(w, "Bad headers", http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var s structType
+	err = xml.Unmarshal(body, &s)
+	if err != nil || s != fooStruct {
+		http.Error(w, "Bad request body", http.StatusBadRequest)
+		return
+	}
+	blob, _ := xml.Marshal(barStruct)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(blob)
+}
+
+func TestXml(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleXml))
+	defer srv.Close()
+	client := New()
+	client.Encoding = XML
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: POST,
+		Data:   fooStruct,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200, r.RawText)
+	assert.Equal(t, r.Result, &barStruct)
+}
+
+func TestResponseEncoding(t *testing.T) {
+	client := New()
+	for ct, enc := range map[string]Encoding{
+		"":                                JSON,
+		"application/json":                JSON,
+		"application/json; charset=utf-8": JSON,
+		"application/vnd.github.v3+json":  JSON,
+		"application/xml":                 XML,
+		"text/xml; charset=ISO-8859-1":    XML,
+		"application/atom+xml":            XML,
+		"text/plain; charset=utf-8":       nil,
+		"text/html":                       nil,
+		"not a / valid ; media type = \"": nil,
+	} {
+		resp := &http.Response{Header: http.Header{}}
+		if ct != "" {
+			resp.Header.Set("Content-Type", ct)
+		}
+		assert.Equal(t, client.responseEncoding(resp), enc, ct)
+	}
+	client.Encoding = XML
+	resp := &http.Response{Header: http.Header{}}
+	assert.Equal(t, client.responseEncoding(resp), XML)
+	// The server's choice of type wins
+	resp.Header.Set("Content-Type", "application/json")
+	assert.Equal(t, client.responseEncoding(resp), JSON)
+	resp.Header.Set("Content-Type", "text/plain")
+	assert.Equal(t, client.responseEncoding(resp), nil)
+	//
+	// Unless the client's Encoding is forced
+	//
+	client.ForceEncoding = true
+	resp = &http.Response{Header: http.Header{"Content-Type": {"application/json"}}}
+	assert.Equal(t, client.responseEncoding(resp), XML)
+	client.Encoding = nil
+	resp.Header.Set("Content-Type", "text/plain")
+	assert.Equal(t, client.responseEncoding(resp), JSON)
+}
+
+func TestForceEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		json.NewEncoder(w).Encode(barStruct)
+	}))
+	defer srv.Close()
+	client := New()
+	client.ForceEncoding = true
+	r := RequestResponse{Url: srv.URL, Method: GET, Result: new(structType)}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, r.Result, &barStruct)
+}
+
+func TestHtmlResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>Oops</body></html>"))
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: GET,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	var de *DecodeError
+	assert.T(t, errors.As(err, &de), err)
+	assert.T(t, strings.Contains(err.Error(), `"text/html"`), err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.RawText, "<html><body>Oops</body></html>")
+	assert.Equal(t, r.Result, new(structType))
+	//
+	// Without a Result there is nothing to decode
+	//
+	r.Result = nil
+	_, err = client.Do(&r)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, r.RawText, "<html><body>Oops</body></html>")
+}
+
+func TestStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Params: fooMap,
+		Result: new(structType),
+		Stream: true,
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Result, &barStruct)
+	assert.Equal(t, r.RawText, "")
+}
+
+func TestJSONEncodingOptions(t *testing.T) {
+	v := map[string]string{"url": "http://foo.com/?a=1&b=<2>"}
+	b, err := JSON.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(b), `{"url":"http://foo.com/?a=1\u0026b=\u003c2\u003e"}`)
+	b, err = JSONEncoding{NoEscapeHTML: true}.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(b), `{"url":"http://foo.com/?a=1&b=<2>"}`)
+	b, err = JSONEncoding{Indent: "  "}.Marshal(fooStruct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(b), "{\n  \"Foo\": 111,\n  \"Bar\": \"foo\"\n}")
+}
+
+func TestMarshalFuncs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePost))
+	defer srv.Close()
+	var marshalled, unmarshalled int
+	c := New()
+	c.Marshal = func(v interface{}) ([]byte, error) {
+		marshalled++
+		return json.Marshal(v)
+	}
+	c.Unmarshal = func(data []byte, v interface{}) error {
+		unmarshalled++
+		return json.Unmarshal(data, v)
+	}
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: POST,
+		Data:   &fooStruct,
+		Result: new(structType),
+	}
+	status, err := c.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, *r.Result.(*structType), barStruct)
+	assert.Equal(t, marshalled, 1)
+	assert.Equal(t, unmarshalled, 1)
+}
+
+// protoMsg stands in for a generated protobuf message.
+type protoMsg struct {
+	Text string
+}
+
+func TestProtobufEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != ProtobufContentType ||
+			req.Header.Get("Accept") != ProtobufContentType {
+			http.Error(w, "Bad headers", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Content-Type", ProtobufContentType)
+		w.Write(bytes.ToUpper(body))
+	}))
+	defer srv.Close()
+	client := New()
+	client.Encoding = NewProtobufEncoding(
+		func(v interface{}) ([]byte, error) { return []byte(v.(*protoMsg).Text), nil },
+		func(b []byte, v interface{}) error {
+			m, ok := v.(*protoMsg)
+			if !ok {
+				return errors.New("Not a message")
+			}
+			m.Text = string(b)
+			return nil
+		},
+	)
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: POST,
+		Data:   &protoMsg{"hello"},
+		Result: new(protoMsg),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200, r.RawText)
+	assert.Equal(t, r.Result, &protoMsg{"HELLO"})
+}
+
+func TestProtobufEncodingNoTarget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status, _ := strconv.Atoi(req.URL.Query().Get("status"))
+		w.Header().Set("Content-Type", ProtobufContentType)
+		w.WriteHeader(status)
+		w.Write([]byte(req.URL.Query().Get("body")))
+	}))
+	defer srv.Close()
+	client := New()
+	// As the documented adapter, the unmarshal func assumes a message
+	client.Encoding = NewProtobufEncoding(
+		func(v interface{}) ([]byte, error) { return []byte(v.(*protoMsg).Text), nil },
+		func(b []byte, v interface{}) error {
+			if string(b) == "bad" {
+				return errors.New("Malformed message")
+			}
+			v.(*protoMsg).Text = string(b)
+			return nil
+		},
+	)
+	for _, status := range []string{"200", "500"} {
+		r := RequestResponse{Url: srv.URL, Params: map[string]string{"status": status, "body": "oops"}}
+		_, err := client.Do(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, r.RawText, "oops")
+		assert.Equal(t, r.Result, nil)
+		assert.Equal(t, r.Error, nil)
+	}
+	//
+	// A malformed body isn't decoded into Raw
+	//
+	r := RequestResponse{Url: srv.URL, Params: map[string]string{"status": "200", "body": "bad"}, Result: new(protoMsg)}
+	_, err := client.Do(&r)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, r.Raw, nil)
+}
+
+func TestMsgpackEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != MsgpackContentType {
+			http.Error(w, "Bad headers", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", MsgpackContentType)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"Message":"bad"}`))
+	}))
+	defer srv.Close()
+	client := New()
+	// JSON stands in for a MessagePack library
+	client.Encoding = NewMsgpackEncoding(json.Marshal, json.Unmarshal)
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: POST,
+		Data:   &fooStruct,
+		Error:  new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 400)
+	assert.Equal(t, r.Error.(*errorStruct).Message, "bad")
+}
+
+func TestCharset(t *testing.T) {
+	latin1 := []byte("<structType><Foo>222</Foo><Bar>caf\xe9</Bar></structType>")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", req.URL.Query().Get("ct"))
+		if req.URL.Query().Get("prolog") != "" {
+			w.Write([]byte(`<?xml version="1.0" encoding="ISO-8859-1"?>`))
+		}
+		w.Write(latin1)
+	}))
+	defer srv.Close()
+	client := New()
+	for _, params := range []map[string]string{
+		{"ct": "text/xml; charset=ISO-8859-1"},
+		{"ct": "text/xml; charset=latin1", "prolog": "1"},
+		{"ct": "text/xml", "prolog": "1"},
+	} {
+		var s structType
+		r := RequestResponse{Url: srv.URL, Method: GET, Params: params, Result: &s}
+		_, err := client.Do(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, s, structType{222, "café"}, params)
+		if params["prolog"] == "" {
+			assert.Equal(t, r.RawText, "<structType><Foo>222</Foo><Bar>café</Bar></structType>")
+		}
+	}
+	//
+	// Other charsets need a CharsetReader
+	//
+	client.CharsetReader = func(cs string, input io.Reader) (io.Reader, error) {
+		if cs != "x-custom" {
+			return nil, errors.New("Unknown charset")
+		}
+		data, err := ioutil.ReadAll(input)
+		return bytes.NewReader(bytes.ReplaceAll(data, []byte{0xe9}, []byte("é!"))), err
+	}
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Params: map[string]string{"ct": "text/plain; charset=X-Custom"},
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, r.RawText, "<structType><Foo>222</Foo><Bar>café!</Bar></structType>")
+}
+
+func TestHtmlErrorResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("<html><body>Bad gateway</body></html>"))
+	}))
+	defer srv.Close()
+	var buf bytes.Buffer
+	for _, enc := range []Encoding{nil, JSON, XML} {
+		client := New()
+		client.Logger = log.New(&buf, "", 0)
+		client.Encoding = enc
+		client.StrictDecode = true
+		r := RequestResponse{
+			Url:    srv.URL,
+			Method: GET,
+			Error:  new(errorStruct),
+		}
+		status, err := client.Do(&r)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, status, 500)
+		assert.Equal(t, r.RawText, "<html><body>Bad gateway</body></html>")
+		assert.Equal(t, r.Error, new(errorStruct))
+	}
+	assert.Equal(t, buf.String(), "")
+}
+
+func TestRawMessage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		code, _ := strconv.Atoi(req.URL.Query().Get("code"))
+		w.WriteHeader(code)
+		w.Write([]byte(req.URL.Query().Get("body")))
+	}))
+	defer srv.Close()
+	client := New()
+	for _, body := range []string{`{"Foo": 1, "Bar": [2, 3]}`, `null`} {
+		for _, stream := range []bool{false, true} {
+			var res json.RawMessage
+			r := RequestResponse{
+				Url:    srv.URL,
+				Method: GET,
+				Params: map[string]string{"code": "200", "body": body},
+				Result: &res,
+				Stream: stream,
+			}
+			_, err := client.Do(&r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, string(res), body)
+		}
+	}
+	var e json.RawMessage
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Params: map[string]string{"code": "400", "body": `{"Message":"bad"}`},
+		Error:  &e,
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(e), `{"Message":"bad"}`)
+}
+
+func TestUseNumber(t *testing.T) {
+	// 2^53 + 1, which float64 rounds to 2^53
+	body := `{"id": 9007199254740993}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	client := New()
+	var m map[string]interface{}
+	r := RequestResponse{Url: srv.URL, Method: GET, Result: &m}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, m["id"], float64(9007199254740992))
+	client.UseNumber = true
+	for _, stream := range []bool{false, true} {
+		m = nil
+		r = RequestResponse{Url: srv.URL, Method: GET, Result: &m, Stream: stream}
+		_, err = client.Do(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, m["id"], json.Number("9007199254740993"))
+		id, err := m["id"].(json.Number).Int64()
+		assert.Equal(t, err, nil)
+		assert.Equal(t, id, int64(9007199254740993))
+	}
+	//
+	// The fallback into Raw too
+	//
+	r = RequestResponse{Url: srv.URL, Method: GET, Result: new([]int)}
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, r.Raw, map[string]interface{}{"id": json.Number("9007199254740993")})
+	//
+	// Typed fields are unaffected, and trailing garbage still rejected
+	//
+	var s struct{ ID int64 }
+	enc := JSONEncoding{UseNumber: true}
+	assert.Equal(t, enc.Unmarshal([]byte(body), &s), nil)
+	assert.Equal(t, s.ID, int64(9007199254740993))
+	assert.NotEqual(t, enc.Unmarshal([]byte(body+"}"), &s), nil)
+	assert.NotEqual(t, enc.Unmarshal([]byte(body+"{}"), &s), nil)
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if req.URL.Path == "/error" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"Status": 400, "Message": "bad", "Extra": true}`))
+			return
+		}
+		w.Write([]byte(`{"Foo": 1, "Bar": "bar", "Baz": "new"}`))
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{Url: srv.URL, Method: GET, Result: new(structType)}
+	_, err := client.Do(&r)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, r.Result, &structType{1, "bar"})
+	client.DisallowUnknownFields = true
+	for _, stream := range []bool{false, true} {
+		r = RequestResponse{Url: srv.URL, Method: GET, Result: new(structType), Stream: stream}
+		_, err = client.Do(&r)
+		var de *DecodeError
+		assert.T(t, errors.As(err, &de), err)
+		assert.T(t, strings.Contains(err.Error(), `unknown field "Baz"`), err)
+	}
+	//
+	// Error responses are decoded as usual
+	//
+	r = RequestResponse{Url: srv.URL + "/error", Method: GET, Error: new(errorStruct)}
+	status, err := client.Do(&r)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, status, 400)
+	assert.Equal(t, r.Error, &errorStruct{400, "bad"})
+}
+
+func TestNegotiatedType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		accept := req.Header.Get("Accept")
+		switch {
+		case strings.Contains(accept, "application/xml"):
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			xml.NewEncoder(w).Encode(barStruct)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(barStruct)
+		}
+	}))
+	defer srv.Close()
+	client := New()
+	h := http.Header{"Accept": {"application/json, application/xml"}}
+	for _, enc := range []Encoding{nil, JSON, XML} {
+		client.Encoding = enc
+		r := RequestResponse{Url: srv.URL, Method: GET, Headers: &h, Result: new(structType)}
+		_, err := client.Do(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.T(t, strings.HasPrefix(r.RawText, "<structType>"), r.RawText)
+		assert.Equal(t, r.Result, &barStruct)
+		//
+		// Streamed too
+		//
+		r = RequestResponse{Url: srv.URL, Method: GET, Headers: &h, Result: new(structType), Stream: true}
+		_, err = client.Do(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, r.Result, &barStruct)
+	}
+	//
+	// An XML client decodes JSON if that's what the server sends
+	//
+	client.Encoding = XML
+	r := RequestResponse{Url: srv.URL, Method: GET, Headers: &http.Header{"Accept": {"application/json"}}, Result: new(structType)}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, r.Result, &barStruct)
+}
+
+func TestEncodingAliases(t *testing.T) {
+	var ct string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ct)
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	unmarshal := func(b []byte, v interface{}) error {
+		v.(*protoMsg).Text = string(b)
+		return nil
+	}
+	marshal := func(v interface{}) ([]byte, error) { return nil, nil }
+	client.Encoding = NewEncoding("application/x-thing", marshal, unmarshal, "application/octet-stream")
+	for _, ct = range []string{"application/x-thing", "application/octet-stream"} {
+		r := RequestResponse{Url: srv.URL, Method: GET, Result: new(protoMsg)}
+		_, err := client.Do(&r)
+		if err != nil {
+			t.Fatal(ct, err)
+		}
+		assert.Equal(t, r.Result, &protoMsg{"hello"})
+	}
+	ct = "application/x-other"
+	_, err := client.Do(&RequestResponse{Url: srv.URL, Method: GET, Result: new(protoMsg)})
+	assert.NotEqual(t, err, nil)
+	//
+	// Protobuf's common aliases are built in
+	//
+	client.Encoding = NewProtobufEncoding(marshal, unmarshal)
+	ct = "application/protobuf"
+	r := RequestResponse{Url: srv.URL, Method: GET, Result: new(protoMsg)}
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, r.Result, &protoMsg{"hello"})
+}
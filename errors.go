@@ -0,0 +1,114 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"errors"
+	"strconv"
+	"unicode/utf8"
+)
+
+// ErrPreconditionFailed is returned by Do when a request made with IfMatch
+// set fails with status 412, meaning the resource has changed since it was
+// fetched.
+var ErrPreconditionFailed = errors.New("Precondition failed: resource has been modified")
+
+// ErrResponseTooLarge is returned by Do when a response body is longer than
+// the client's MaxResponseBytes.
+var ErrResponseTooLarge = errors.New("Response body exceeds MaxResponseBytes")
+
+// ErrRequestTooLarge is returned by Do when a request body is longer than
+// the client's MaxRequestBytes.
+var ErrRequestTooLarge = errors.New("Request body exceeds MaxRequestBytes")
+
+// A StatusError is returned by Do when the server responds with a non-2xx
+// status and the client's ErrorOnStatus flag is set.
+type StatusError struct {
+	Status  int         // HTTP status of the response
+	Payload interface{} // Error response, as unmarshalled into RequestResponse.Error
+	Body    string      // Start of the response body, included in the message
+}
+
+func (e *StatusError) Error() string {
+	s := "Server returned status " + strconv.Itoa(e.Status)
+	if e.Body != "" {
+		s += ": " + e.Body
+	}
+	return s
+}
+
+// DefaultErrorBodyBytes is how much of a response body is included in a
+// StatusError's message if the client's ErrorBodyBytes is zero.
+const DefaultErrorBodyBytes = 512
+
+// statusError returns a *StatusError for r, whose response had the given
+// status.
+func (c *Client) statusError(r *RequestResponse, status int) *StatusError {
+	e := &StatusError{Status: status, Payload: r.Error}
+	n := c.ErrorBodyBytes
+	if n == 0 {
+		n = DefaultErrorBodyBytes
+	}
+	if n < 0 || r.RawText == "" {
+		return e
+	}
+	body := r.RawText
+	if c.RedactErrorBody != nil {
+		body = c.RedactErrorBody(body)
+	}
+	if len(body) > n {
+		// Don't split a UTF-8 sequence
+		for n > 0 && !utf8.RuneStart(body[n]) {
+			n--
+		}
+		body = body[:n] + "... (truncated)"
+	}
+	e.Body = body
+	return e
+}
+
+// A DecodeError is returned by Do when the server's response cannot be
+// unmarshalled.
+type DecodeError struct {
+	Status  int    // HTTP status of the response
+	RawText string // Raw text of the response
+	Err     error  // Error returned by the decoder
+}
+
+func (e *DecodeError) Error() string {
+	return "Cannot decode response with status " + strconv.Itoa(e.Status) + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// A Phase identifies the stage of a request at which a RequestError arose.
+type Phase string
+
+const (
+	URLPhase       = Phase("URL parsing")
+	EncodePhase    = Phase("Body encoding")
+	TransportPhase = Phase("Transport")
+)
+
+// A RequestError is returned by Do when a request cannot be built or sent:
+// its URL is malformed, its body cannot be encoded, or the transport fails.
+// Cancellation and timeouts are instead reported as the context's error, and
+// undecodable responses as a *DecodeError.
+type RequestError struct {
+	Phase Phase  // Stage at which the request failed
+	URL   string // URL of the request, as given if it could not be parsed
+	Err   error  // Underlying error
+}
+
+func (e *RequestError) Error() string {
+	return string(e.Phase) + " failed for " + strconv.Quote(e.URL) + ": " + e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
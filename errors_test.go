@@ -0,0 +1,194 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"errors"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	client := New()
+	client.ErrorOnStatus = true
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: GET,
+		Params: map[string]string{"bad": "value"},
+		Error:  new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	assert.Equal(t, status, 500)
+	var se *StatusError
+	assert.T(t, errors.As(err, &se))
+	assert.Equal(t, se.Status, 500)
+	assert.Equal(t, se.Payload.(*errorStruct).Message, "Bad query params: bad=value")
+}
+
+func TestStatusErrorBody(t *testing.T) {
+	body := `{"error":"token=secret"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		if req.URL.Path == "/long" {
+			w.Write([]byte(`"` + strings.Repeat("é", 1000) + `"`))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	client := New()
+	client.ErrorOnStatus = true
+	r := RequestResponse{Url: srv.URL, Method: GET}
+	_, err := client.Do(&r)
+	assert.Equal(t, err.Error(), "Server returned status 400: "+body)
+	//
+	// Long bodies are truncated, between runes
+	//
+	r.Url = srv.URL + "/long"
+	_, err = client.Do(&r)
+	var se *StatusError
+	assert.T(t, errors.As(err, &se))
+	assert.Equal(t, se.Body, `"`+strings.Repeat("é", 255)+"... (truncated)")
+	client.ErrorBodyBytes = 10
+	_, err = client.Do(&r)
+	assert.T(t, errors.As(err, &se))
+	assert.Equal(t, se.Body, `"`+strings.Repeat("é", 4)+"... (truncated)")
+	//
+	// Masked or omitted
+	//
+	r.Url = srv.URL
+	client.ErrorBodyBytes = 0
+	client.RedactErrorBody = func(s string) string {
+		return strings.Replace(s, "secret", "***", -1)
+	}
+	_, err = client.Do(&r)
+	assert.Equal(t, err.Error(), `Server returned status 400: {"error":"token=***"}`)
+	client.ErrorBodyBytes = -1
+	_, err = client.Do(&r)
+	assert.Equal(t, err.Error(), "Server returned status 400")
+}
+
+func TestDecodeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{not json"))
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: GET,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	assert.Equal(t, status, 200)
+	var de *DecodeError
+	assert.T(t, errors.As(err, &de))
+	assert.Equal(t, de.Status, 200)
+	assert.Equal(t, de.RawText, "{not json")
+}
+
+func TestStrictDecode(t *testing.T) {
+	body := `{"Foo": "not a number"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Result: new(structType),
+	}
+	//
+	// Lenient mode falls back to decoding into Raw...
+	//
+	_, err := client.Do(&r)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, r.Raw, map[string]interface{}{"Foo": "not a number"})
+	//
+	// ...but strict mode returns the error
+	//
+	client.StrictDecode = true
+	r.Raw = nil
+	_, err = client.Do(&r)
+	var de *DecodeError
+	assert.T(t, errors.As(err, &de))
+	assert.Equal(t, r.Raw, nil)
+	//
+	// Malformed JSON is an error in either mode
+	//
+	body = `{"Foo": `
+	for _, strict := range []bool{false, true} {
+		client.StrictDecode = strict
+		_, err = client.Do(&r)
+		assert.T(t, errors.As(err, &de))
+		assert.Equal(t, r.RawText, body)
+	}
+}
+
+func TestRequestErrorOnStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Error:  new(errorStruct),
+	}
+	_, err := client.Do(&r)
+	assert.Equal(t, err, nil)
+	r.ErrorOnStatus = true
+	_, err = client.Do(&r)
+	var se *StatusError
+	assert.T(t, errors.As(err, &se))
+	assert.Equal(t, se.Payload, r.Error)
+	//
+	// Successful responses are never errors
+	//
+	r.Params = fooMap
+	_, err = client.Do(&r)
+	assert.Equal(t, err, nil)
+}
+
+func TestRequestError(t *testing.T) {
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	_, err := client.Do(&RequestResponse{Url: "http://%zz/", Method: GET})
+	var re *RequestError
+	assert.T(t, errors.As(err, &re), err)
+	assert.Equal(t, re.Phase, URLPhase)
+	assert.Equal(t, re.URL, "http://%zz/")
+	assert.T(t, strings.Contains(err.Error(), `"http://%zz/"`), err)
+	//
+	// Encoding
+	//
+	r := RequestResponse{Url: "http://127.0.0.1:1/", Method: POST, Data: func() {}}
+	_, err = client.Do(&r)
+	assert.T(t, errors.As(err, &re), err)
+	assert.Equal(t, re.Phase, EncodePhase)
+	//
+	// Transport
+	//
+	_, err = client.Do(&RequestResponse{Url: "http://127.0.0.1:1/", Method: GET})
+	assert.T(t, errors.As(err, &re), err)
+	assert.Equal(t, re.Phase, TransportPhase)
+	assert.Equal(t, re.URL, "http://127.0.0.1:1/")
+	var ue *url.Error
+	assert.T(t, errors.As(err, &ue))
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// decodeHeaders stores the values of the headers in h in the fields of the
+// struct pointed to by v which carry a header tag.  Fields may be strings,
+// []strings (receiving every value of a repeated header), bools, numbers,
+// time.Durations (given as seconds, like Retry-After), or time.Times (given
+// as HTTP dates or RFC 3339 timestamps).  Fields whose headers are missing,
+// and unexported fields, are left untouched.
+func decodeHeaders(h http.Header, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("HeaderResult must be a pointer to a struct")
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name := rt.Field(i).Tag.Get("header")
+		vv := h.Values(name)
+		if name == "" || len(vv) == 0 || !rv.Field(i).CanSet() {
+			continue
+		}
+		err := setHeaderField(rv.Field(i), vv)
+		if err != nil {
+			return errors.New("Cannot decode header " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// setHeaderField stores the header values vv in the field f.
+func setHeaderField(f reflect.Value, vv []string) error {
+	s := vv[0]
+	switch f.Type() {
+	case timeType:
+		t, err := http.ParseTime(s)
+		if err != nil {
+			t, err = time.Parse(time.RFC3339, s)
+		}
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n * float64(time.Second)))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return errors.New("Unsupported field type " + f.Type().String())
+		}
+		f.Set(reflect.ValueOf(append([]string(nil), vv...)).Convert(f.Type()))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	default:
+		return errors.New("Unsupported field type " + f.Type().String())
+	}
+	return nil
+}
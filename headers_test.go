@@ -0,0 +1,70 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"errors"
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type pageHeaders struct {
+	Total     int           `header:"X-Total-Count"`
+	Remaining uint          `header:"X-RateLimit-Remaining"`
+	Reset     time.Time     `header:"X-RateLimit-Reset"`
+	RetryIn   time.Duration `header:"X-Retry-In"`
+	Links     []string      `header:"Link"`
+	Missing   string        `header:"X-Missing"`
+	Untagged  string
+	total     int `header:"X-Total-Count"` // Unexported, so ignored
+}
+
+func TestHeaderResult(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Total-Count", req.URL.Query().Get("total"))
+		w.Header().Set("X-RateLimit-Remaining", "7")
+		w.Header().Set("X-RateLimit-Reset", "Wed, 21 Oct 2015 07:28:00 GMT")
+		w.Header().Set("X-Retry-In", "1.5")
+		w.Header().Add("Link", "<a>")
+		w.Header().Add("Link", "<b>")
+	}))
+	defer srv.Close()
+	client := New()
+	var h pageHeaders
+	r := RequestResponse{
+		Url:          srv.URL,
+		Method:       GET,
+		Params:       map[string]string{"total": "42"},
+		HeaderResult: &h,
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, h, pageHeaders{
+		Total:     42,
+		Remaining: 7,
+		Reset:     time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC),
+		RetryIn:   1500 * time.Millisecond,
+		Links:     []string{"<a>", "<b>"},
+	})
+	//
+	// Malformed numbers
+	//
+	r = RequestResponse{
+		Url:          srv.URL,
+		Method:       GET,
+		Params:       map[string]string{"total": "lots"},
+		HeaderResult: &h,
+	}
+	_, err = client.Do(&r)
+	var de *DecodeError
+	assert.T(t, errors.As(err, &de), err)
+	assert.NotEqual(t, r.Duration, time.Duration(0))
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+// Get issues a GET request to url, unmarshalling a successful response into
+// result.
+func (c *Client) Get(url string, result interface{}) (status int, err error) {
+	return c.Do(&RequestResponse{Url: url, Method: GET, Result: result})
+}
+
+// Post issues a POST request to url with data as its body, unmarshalling a
+// successful response into result.
+func (c *Client) Post(url string, data, result interface{}) (status int, err error) {
+	return c.Do(&RequestResponse{Url: url, Method: POST, Data: data, Result: result})
+}
+
+// Put issues a PUT request to url with data as its body, unmarshalling a
+// successful response into result.
+func (c *Client) Put(url string, data, result interface{}) (status int, err error) {
+	return c.Do(&RequestResponse{Url: url, Method: PUT, Data: data, Result: result})
+}
+
+// Patch issues a PATCH request to url with data as its body, unmarshalling a
+// successful response into result.
+func (c *Client) Patch(url string, data, result interface{}) (status int, err error) {
+	return c.Do(&RequestResponse{Url: url, Method: PATCH, Data: data, Result: result})
+}
+
+// Delete issues a DELETE request to url, unmarshalling a successful response
+// into result.
+func (c *Client) Delete(url string, result interface{}) (status int, err error) {
+	return c.Do(&RequestResponse{Url: url, Method: DELETE, Result: result})
+}
+
+// Get issues a GET request using the default client.
+func Get(url string, result interface{}) (status int, err error) {
+	return defaultClient.Get(url, result)
+}
+
+// Post issues a POST request using the default client.
+func Post(url string, data, result interface{}) (status int, err error) {
+	return defaultClient.Post(url, data, result)
+}
+
+// Put issues a PUT request using the default client.
+func Put(url string, data, result interface{}) (status int, err error) {
+	return defaultClient.Put(url, data, result)
+}
+
+// Patch issues a PATCH request using the default client.
+func Patch(url string, data, result interface{}) (status int, err error) {
+	return defaultClient.Patch(url, data, result)
+}
+
+// Delete issues a DELETE request using the default client.
+func Delete(url string, result interface{}) (status int, err error) {
+	return defaultClient.Delete(url, result)
+}
+
+// DoInto executes r with c, unmarshalling a successful response into a new T
+// which replaces r.Result, and returns it.
+func DoInto[T any](c *Client, r *RequestResponse) (result T, status int, err error) {
+	p := new(T)
+	r.Result = p
+	status, err = c.Do(r)
+	return *p, status, err
+}
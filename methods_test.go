@@ -0,0 +1,70 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetHelper(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	var res structType
+	status, err := Get(srv.URL+"?foo=bar", &res)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, res, barStruct)
+}
+
+func TestPostHelper(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePost))
+	defer srv.Close()
+	client := New()
+	client.BaseURL = srv.URL
+	var res structType
+	status, err := client.Post("foo", fooStruct, &res)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, res, barStruct)
+}
+
+func TestDoInto(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	res, status, err := DoInto[structType](New(), &RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Params: fooMap,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, res, barStruct)
+}
+
+func TestSetDefaultClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	old := DefaultClient()
+	defer SetDefaultClient(old)
+	SetDefaultClient(New(WithBaseURL(srv.URL)))
+	var res structType
+	status, err := Get("foo?foo=bar", &res)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, res, barStruct)
+	assert.Equal(t, DefaultClient().BaseURL, srv.URL)
+}
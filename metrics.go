@@ -0,0 +1,27 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"time"
+)
+
+// Metrics records measurements of the requests made by a Client, for export
+// to a monitoring system such as Prometheus.
+type Metrics interface {
+	// ObserveRequest is called once for each request executed by Do, after
+	// any retries.  Status is 0 if no response was received.
+	ObserveRequest(method, host string, status int, duration time.Duration)
+}
+
+// observe reports the completed request r to the client's Metrics.
+func (c *Client) observe(r *RequestResponse) {
+	var host string
+	if r.SentURL != nil {
+		host = r.SentURL.Host
+	}
+	c.Metrics.ObserveRequest(string(r.Method), host, r.Status, r.Duration)
+}
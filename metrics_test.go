@@ -0,0 +1,47 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type observation struct {
+	method string
+	host   string
+	status int
+}
+
+type testMetrics []observation
+
+func (m *testMetrics) ObserveRequest(method, host string, status int, duration time.Duration) {
+	*m = append(*m, observation{method, host, status})
+}
+
+func TestMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	metrics := new(testMetrics)
+	client := New()
+	client.Metrics = metrics
+	for _, params := range []map[string]string{fooMap, barMap} {
+		r := RequestResponse{
+			Url:    srv.URL,
+			Method: GET,
+			Params: params,
+		}
+		client.Do(&r)
+	}
+	host := srv.Listener.Addr().String()
+	assert.Equal(t, *metrics, testMetrics{
+		{"GET", host, 200},
+		{"GET", host, 500},
+	})
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+// A Handler executes a REST request.
+type Handler func(r *RequestResponse) (status int, err error)
+
+// A Middleware wraps the execution of a REST request.  It may inspect or
+// modify r, then call next to continue executing the request, or return
+// without calling next to short-circuit it.
+type Middleware func(r *RequestResponse, next Handler) (status int, err error)
+
+// Use installs middleware on the client.  Middleware runs in the order it was
+// installed, so the first Middleware sees the request first and the response
+// last.  Use should not be called concurrently with Do.
+func (c *Client) Use(m ...Middleware) {
+	c.middleware = append(c.middleware, m...)
+}
+
+// handler returns the client's core Handler, wrapped in its middleware.
+func (c *Client) handler() Handler {
+	h := Handler(c.do)
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		m, next := c.middleware[i], h
+		h = func(r *RequestResponse) (int, error) {
+			return m(r, next)
+		}
+	}
+	return h
+}
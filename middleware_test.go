@@ -0,0 +1,88 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"errors"
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	var trace []string
+	tracer := func(name string) Middleware {
+		return func(r *RequestResponse, next Handler) (int, error) {
+			trace = append(trace, name+" before")
+			status, err := next(r)
+			trace = append(trace, name+" after")
+			return status, err
+		}
+	}
+	client := New()
+	client.Use(tracer("outer"), tracer("inner"))
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Params: fooMap,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, trace, []string{"outer before", "inner before", "inner after", "outer after"})
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	denied := errors.New("denied")
+	client := New()
+	client.Use(func(r *RequestResponse, next Handler) (int, error) {
+		return 0, denied
+	})
+	r := RequestResponse{
+		Url:    "http://localhost:0",
+		Method: GET,
+	}
+	_, err := client.Do(&r)
+	assert.Equal(t, err, denied)
+}
+
+func TestHooks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Trace") != "on" {
+			JsonError(w, "Missing trace header", http.StatusBadRequest)
+			return
+		}
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	var seen *RequestResponse
+	client := New()
+	client.OnRequest = func(req *http.Request) {
+		req.Header.Set("X-Trace", "on")
+	}
+	client.OnResponse = func(r *RequestResponse) {
+		seen = r
+	}
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Params: fooMap,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, seen, &r)
+	assert.Equal(t, seen.Result, &barStruct)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// A MockResponse is a canned response returned by a MockTransport.
+type MockResponse struct {
+	Status int         // HTTP status; 0 means 200
+	Header http.Header // Response headers; Content-Type defaults to application/json
+	Body   string
+}
+
+// A MockRequest records a request received by a MockTransport.
+type MockRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// MockTransport is an http.RoundTripper which answers requests with canned
+// responses, for testing code which uses a Client without a server.  Assign
+// it to the client's HttpClient.Transport.  It is safe for concurrent use.
+type MockTransport struct {
+	mu       sync.Mutex
+	routes   []mockRoute
+	requests []*MockRequest
+}
+
+type mockRoute struct {
+	method  string
+	pattern string
+	resp    MockResponse
+	calls   int
+}
+
+// NewMockTransport returns a MockTransport with no responses.
+func NewMockTransport() *MockTransport {
+	return new(MockTransport)
+}
+
+// Handle arranges for requests with the given method whose URL path matches
+// pattern, as per path.Match, to receive resp.  An empty method matches any
+// method.  Routes are tried in the order they were added.
+func (m *MockTransport) Handle(method Method, pattern string, resp MockResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routes = append(m.routes, mockRoute{method: string(method), pattern: pattern, resp: resp})
+}
+
+// RoundTrip answers req from the first matching route, returning an error
+// if there is none.
+func (m *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	mr := &MockRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header.Clone(),
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		mr.Body = body
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, mr)
+	p := req.URL.Path
+	if p == "" {
+		p = "/"
+	}
+	for i := range m.routes {
+		rt := &m.routes[i]
+		if rt.method != "" && rt.method != req.Method {
+			continue
+		}
+		if ok, _ := path.Match(rt.pattern, p); !ok {
+			continue
+		}
+		rt.calls++
+		status := rt.resp.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		header := rt.resp.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/json")
+		}
+		return &http.Response{
+			Status:        http.StatusText(status),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewBufferString(rt.resp.Body)),
+			ContentLength: int64(len(rt.resp.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, errors.New("No mock response for " + req.Method + " " + req.URL.String())
+}
+
+// Calls returns the number of requests answered by the route with the given
+// method and pattern.
+func (m *MockTransport) Calls(method Method, pattern string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, rt := range m.routes {
+		if rt.method == string(method) && rt.pattern == pattern {
+			n += rt.calls
+		}
+	}
+	return n
+}
+
+// Requests returns the requests received so far, in order, including any
+// which matched no route.
+func (m *MockTransport) Requests() []*MockRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*MockRequest(nil), m.requests...)
+}
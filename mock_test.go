@@ -0,0 +1,44 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"testing"
+)
+
+func TestMockTransport(t *testing.T) {
+	m := NewMockTransport()
+	m.Handle(POST, "/users", MockResponse{Status: 201, Body: `{"Foo":222,"Bar":"bar"}`})
+	m.Handle(GET, "/users/*", MockResponse{Status: 404, Body: `{"Status":404,"Message":"Not found"}`})
+	client := New()
+	client.HttpClient.Transport = m
+	client.BaseURL = "http://api.example.com"
+	var res structType
+	status, err := client.Post("/users", &fooStruct, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 201)
+	assert.Equal(t, res, barStruct)
+	e := new(errorStruct)
+	r := RequestResponse{Url: "/users/42", Method: GET, Error: e}
+	status, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 404)
+	assert.Equal(t, e.Message, "Not found")
+	_, err = client.Get("/groups", nil)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, m.Calls(POST, "/users"), 1)
+	assert.Equal(t, m.Calls(GET, "/users/*"), 1)
+	reqs := m.Requests()
+	assert.Equal(t, len(reqs), 3)
+	assert.Equal(t, reqs[0].URL, "http://api.example.com/users")
+	assert.Equal(t, string(reqs[0].Body), `{"Foo":111,"Bar":"foo"}`)
+	assert.Equal(t, reqs[2].Method, "GET")
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Multipart describes a multipart/form-data request body.  File contents
+// are streamed to the server as the request is sent, so a Multipart can only
+// be used for a single request.
+type Multipart struct {
+	Fields map[string]string
+	Files  []MultipartFile
+}
+
+// A MultipartFile is a single file to be uploaded in a Multipart body.
+type MultipartFile struct {
+	Field       string    // Name of the form field
+	Filename    string    // Filename reported to the server
+	ContentType string    // Content type of this part; defaults to application/octet-stream
+	Reader      io.Reader // File contents (required)
+	path        string    // File to read, if Reader is nil
+}
+
+// withFiles returns a Multipart with the fields and files of m, which may be
+// nil, and in addition the files at the paths given in files, keyed by field
+// name.  Each file's name is taken from its path, and its content type from
+// its extension.
+func (m *Multipart) withFiles(files map[string]string) *Multipart {
+	m2 := new(Multipart)
+	if m != nil {
+		m2.Fields = m.Fields
+		m2.Files = append(m2.Files, m.Files...)
+	}
+	fields := make([]string, 0, len(files))
+	for k := range files {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		path := files[field]
+		m2.Files = append(m2.Files, MultipartFile{
+			Field:       field,
+			Filename:    filepath.Base(path),
+			ContentType: mime.TypeByExtension(filepath.Ext(path)),
+			path:        path,
+		})
+	}
+	return m2
+}
+
+// checkFiles returns an error if any of the files at the paths in files
+// cannot be opened.
+func checkFiles(files map[string]string) error {
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// check returns an error if any of the files of m has no contents.
+func (m *Multipart) check() error {
+	for i := range m.Files {
+		if m.Files[i].Reader == nil {
+			return errors.New("MultipartFile " + strconv.Quote(m.Files[i].Field) + " has no Reader")
+		}
+	}
+	return nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// stream returns a reader from which the encoded body can be read, and the
+// content type, including boundary, with which it must be sent.
+func (m *Multipart) stream() (io.Reader, string) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(m.write(mw))
+	}()
+	return pr, mw.FormDataContentType()
+}
+
+// write encodes the fields and files of m to mw.
+func (m *Multipart) write(mw *multipart.Writer) error {
+	keys := make([]string, 0, len(m.Fields))
+	for k := range m.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		err := mw.WriteField(k, m.Fields[k])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range m.Files {
+		f := &m.Files[i]
+		ct := f.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(f.Field), quoteEscaper.Replace(f.Filename)))
+		h.Set("Content-Type", ct)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		err = f.copy(w)
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// copy writes the contents of f to w.
+func (f *MultipartFile) copy(w io.Writer) error {
+	if f.Reader != nil {
+		_, err := io.Copy(w, f.Reader)
+		return err
+	}
+	if f.path == "" {
+		return errors.New("MultipartFile " + strconv.Quote(f.Field) + " has no Reader")
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"errors"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func HandleMultipart(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseMultipartForm(1 << 20)
+	if err != nil {
+		JsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.FormValue("foo") != "bar" {
+		JsonError(w, "Bad field value", http.StatusBadRequest)
+		return
+	}
+	f, fh, err := req.FormFile("upload")
+	if err != nil {
+		JsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+	b, _ := ioutil.ReadAll(f)
+	if string(b) != "file contents" || fh.Filename != "spam.txt" ||
+		fh.Header.Get("Content-Type") != "text/plain" {
+		JsonError(w, "Bad file part", http.StatusBadRequest)
+		return
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleMultipart))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: POST,
+		Multipart: &Multipart{
+			Fields: map[string]string{"foo": "bar"},
+			Files: []MultipartFile{{
+				Field:       "upload",
+				Filename:    "spam.txt",
+				ContentType: "text/plain",
+				Reader:      strings.NewReader("file contents"),
+			}},
+		},
+		Error: new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200, r.RawText)
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "spam.json")
+	err := ioutil.WriteFile(path, []byte(`{"spam":"eggs"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := req.ParseMultipartForm(1 << 20)
+		if err != nil {
+			JsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, fh, err := req.FormFile("upload")
+		if err != nil {
+			JsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		b, _ := ioutil.ReadAll(f)
+		if string(b) != `{"spam":"eggs"}` || fh.Filename != "spam.json" ||
+			fh.Header.Get("Content-Type") != "application/json" ||
+			req.FormValue("foo") != "bar" {
+			JsonError(w, "Bad file part", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:       srv.URL,
+		Method:    POST,
+		Multipart: &Multipart{Fields: map[string]string{"foo": "bar"}},
+		Files:     map[string]string{"upload": path},
+		Error:     new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200, r.RawText)
+	//
+	// Missing files fail before anything is sent
+	//
+	r = RequestResponse{
+		Url:    "http://127.0.0.1:1/",
+		Method: POST,
+		Files:  map[string]string{"upload": filepath.Join(dir, "missing")},
+	}
+	_, err = client.Do(&r)
+	assert.T(t, errors.Is(err, os.ErrNotExist), err)
+}
+
+func TestMultipartAbandoned(t *testing.T) {
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	abort := errors.New("Abort")
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		r := RequestResponse{
+			Url:    "http://127.0.0.1:1/",
+			Method: POST,
+			Multipart: &Multipart{
+				Files: []MultipartFile{{
+					Field:    "upload",
+					Filename: "spam.txt",
+					Reader:   strings.NewReader(strings.Repeat("spam", 1<<16)),
+				}},
+			},
+			PrepareRequest: func(req *http.Request) error { return abort },
+		}
+		_, err := client.Do(&r)
+		assert.Equal(t, err, abort)
+	}
+	// The goroutines streaming the bodies exit once they are closed
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.T(t, runtime.NumGoroutine() <= before, runtime.NumGoroutine(), before)
+}
+
+func TestMultipartNoReader(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{
+		Url:       srv.URL,
+		Method:    POST,
+		Multipart: &Multipart{Files: []MultipartFile{{Field: "f", Filename: "a"}}},
+	}
+	_, err := client.Do(&r)
+	assert.NotEqual(t, err, nil)
+	assert.T(t, strings.Contains(err.Error(), `MultipartFile "f" has no Reader`), err)
+	assert.Equal(t, hits, int32(0))
+}
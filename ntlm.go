@@ -0,0 +1,279 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM messages, as per [MS-NLMP].  Only NTLMv2 responses are sent.
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+const (
+	ntlmUnicode           = 0x00000001
+	ntlmOEM               = 0x00000002
+	ntlmRequestTarget     = 0x00000004
+	ntlmNTLM              = 0x00000200
+	ntlmAlwaysSign        = 0x00008000
+	ntlmExtendedSecurity  = 0x00080000
+	ntlmNegotiateFlags    = ntlmUnicode | ntlmOEM | ntlmRequestTarget | ntlmNTLM | ntlmAlwaysSign | ntlmExtendedSecurity
+	ntlmAvEOL             = 0
+	ntlmAvTimestamp       = 7
+	ntlmChallengeMinBytes = 48
+)
+
+// ntlmNegotiate returns an NTLM NEGOTIATE_MESSAGE.
+func ntlmNegotiate() []byte {
+	b := make([]byte, 32)
+	copy(b, ntlmSignature)
+	binary.LittleEndian.PutUint32(b[8:], 1)
+	binary.LittleEndian.PutUint32(b[12:], ntlmNegotiateFlags)
+	return b
+}
+
+// An ntlmChallenge is a parsed NTLM CHALLENGE_MESSAGE.
+type ntlmChallenge struct {
+	flags      uint32
+	challenge  []byte // Server challenge
+	targetInfo []byte // AV pairs
+}
+
+// parseNTLMChallenge parses the NTLM CHALLENGE_MESSAGE msg.
+func parseNTLMChallenge(msg []byte) (*ntlmChallenge, error) {
+	if len(msg) < ntlmChallengeMinBytes || !bytes.Equal(msg[:8], ntlmSignature) ||
+		binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return nil, errors.New("Invalid NTLM challenge")
+	}
+	ch := &ntlmChallenge{
+		flags:     binary.LittleEndian.Uint32(msg[20:]),
+		challenge: msg[24:32],
+	}
+	n := int(binary.LittleEndian.Uint16(msg[40:]))
+	off := int(binary.LittleEndian.Uint32(msg[44:]))
+	if off > len(msg) || n > len(msg)-off {
+		return nil, errors.New("Invalid NTLM challenge")
+	}
+	ch.targetInfo = msg[off : off+n]
+	return ch, nil
+}
+
+// timestamp returns the time given in the challenge's target info, if any.
+func (ch *ntlmChallenge) timestamp() ([]byte, bool) {
+	info := ch.targetInfo
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		n := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == ntlmAvEOL || n > len(info)-4 {
+			break
+		}
+		if id == ntlmAvTimestamp && n == 8 {
+			return info[4:12], true
+		}
+		info = info[4+n:]
+	}
+	return nil, false
+}
+
+// ntlmAuthenticate returns the NTLM AUTHENTICATE_MESSAGE answering ch, for
+// user - "DOMAIN\user" or just "user" - with password pwd.  The client
+// challenge is cc, and now is used if the server gives no timestamp.
+func ntlmAuthenticate(ch *ntlmChallenge, user, pwd string, cc []byte, now time.Time) []byte {
+	var domain string
+	if i := strings.IndexByte(user, '\\'); i >= 0 {
+		domain, user = user[:i], user[i+1:]
+	}
+	ts, ok := ch.timestamp()
+	if !ok {
+		ts = make([]byte, 8)
+		binary.LittleEndian.PutUint64(ts, fileTime(now))
+	}
+	lm, nt := ntlmv2Responses(user, domain, pwd, ch.challenge, cc, ts, ch.targetInfo)
+	fields := [][]byte{lm, nt, utf16le(domain), utf16le(user), nil, nil}
+	const headerBytes = 64
+	b := make([]byte, headerBytes)
+	copy(b, ntlmSignature)
+	binary.LittleEndian.PutUint32(b[8:], 3)
+	for i, f := range fields {
+		p := b[12+8*i:]
+		binary.LittleEndian.PutUint16(p, uint16(len(f)))
+		binary.LittleEndian.PutUint16(p[2:], uint16(len(f)))
+		binary.LittleEndian.PutUint32(p[4:], uint32(len(b)))
+		b = append(b, f...)
+	}
+	flags := ch.flags&ntlmNegotiateFlags | ntlmUnicode | ntlmNTLM
+	flags &^= ntlmOEM
+	binary.LittleEndian.PutUint32(b[60:], flags)
+	return b
+}
+
+// ntlmv2Responses returns the LMv2 and NTLMv2 responses to the server
+// challenge sc, as per [MS-NLMP] 3.3.2.
+func ntlmv2Responses(user, domain, pwd string, sc, cc, ts, targetInfo []byte) (lm, nt []byte) {
+	ntHash := md4Sum(utf16le(pwd))
+	key := hmacMD5(ntHash[:], utf16le(strings.ToUpper(user)+domain))
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, ts...)
+	temp = append(temp, cc...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	proof := hmacMD5(key, append(append([]byte(nil), sc...), temp...))
+	nt = append(proof, temp...)
+	lm = append(hmacMD5(key, append(append([]byte(nil), sc...), cc...)), cc...)
+	return
+}
+
+func hmacMD5(key, data []byte) []byte {
+	h := hmac.New(md5.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// utf16le encodes s as little-endian UTF-16.
+func utf16le(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+// fileTime returns t as a Windows FILETIME: the number of 100ns intervals
+// since 1601.
+func fileTime(t time.Time) uint64 {
+	const epochDelta = 116444736000000000 // 1601 to 1970, in 100ns
+	return uint64(t.UnixNano()/100) + epochDelta
+}
+
+// md4Sum returns the MD4 digest of data, as per RFC 1320.  MD4 is broken,
+// but NTLM requires it, and the standard library lacks it.
+func md4Sum(data []byte) [16]byte {
+	n := len(data)
+	msg := append(append([]byte(nil), data...), 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(n)<<3)
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+	var x [16]uint32
+	for ; len(msg) > 0; msg = msg[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[4*i:])
+		}
+		aa, bb, cc, dd := a, b, c, d
+		for i := 0; i < 16; i++ {
+			k := i
+			s := [4]int{3, 7, 11, 19}[i%4]
+			f := b&c | ^b&d
+			a, b, c, d = d, bits.RotateLeft32(a+f+x[k], s), b, c
+		}
+		for i := 0; i < 16; i++ {
+			k := i%4*4 + i/4
+			s := [4]int{3, 5, 9, 13}[i%4]
+			g := b&c | b&d | c&d
+			a, b, c, d = d, bits.RotateLeft32(a+g+x[k]+0x5a827999, s), b, c
+		}
+		for i := 0; i < 16; i++ {
+			k := [16]int{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15}[i]
+			s := [4]int{3, 9, 11, 15}[i%4]
+			h := b ^ c ^ d
+			a, b, c, d = d, bits.RotateLeft32(a+h+x[k]+0x6ed9eba1, s), b, c
+		}
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+	var sum [16]byte
+	binary.LittleEndian.PutUint32(sum[0:], a)
+	binary.LittleEndian.PutUint32(sum[4:], b)
+	binary.LittleEndian.PutUint32(sum[8:], c)
+	binary.LittleEndian.PutUint32(sum[12:], d)
+	return sum
+}
+
+// ntlmChallengeHeader returns the argument of the NTLM challenge among the
+// WWW-Authenticate headers in h, which is empty for the initial challenge,
+// and whether there is one.
+func ntlmChallengeHeader(h http.Header) (string, bool) {
+	for _, v := range h.Values("WWW-Authenticate") {
+		if strings.EqualFold(v, "NTLM") {
+			return "", true
+		}
+		if len(v) > 5 && strings.EqualFold(v[:5], "NTLM ") {
+			return strings.TrimSpace(v[5:]), true
+		}
+	}
+	return "", false
+}
+
+// ntlmAuth answers an NTLM challenge in resp, the response to req, by
+// performing the NTLM handshake with the credentials in r.Userinfo: req is
+// sent again with a NEGOTIATE_MESSAGE, and then with the AUTHENTICATE_MESSAGE
+// answering the server's challenge.  NTLM authenticates connections, not
+// requests, so the transport must reuse the connection between the steps,
+// as HTTP/1.1 keep-alive does.  If resp carries no NTLM challenge, or the
+// handshake cannot proceed, the last response is returned as it is.
+func (c *Client) ntlmAuth(hc *http.Client, r *RequestResponse, req *http.Request, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode != http.StatusUnauthorized || r.Userinfo == nil {
+		return resp, nil
+	}
+	if _, ok := ntlmChallengeHeader(resp.Header); !ok || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+	resp, err := c.resendNTLM(hc, req, resp, ntlmNegotiate())
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	arg, ok := ntlmChallengeHeader(resp.Header)
+	if !ok || arg == "" {
+		return resp, nil
+	}
+	msg, err := base64.StdEncoding.DecodeString(arg)
+	if err != nil {
+		return resp, nil
+	}
+	ch, err := parseNTLMChallenge(msg)
+	if err != nil {
+		return resp, nil
+	}
+	cc := make([]byte, 8)
+	_, err = rand.Read(cc)
+	if err != nil {
+		return resp, nil
+	}
+	pwd, _ := r.Userinfo.Password()
+	return c.resendNTLM(hc, req, resp, ntlmAuthenticate(ch, r.Userinfo.Username(), pwd, cc, c.now()))
+}
+
+// resendNTLM discards resp, and sends req again carrying the NTLM message
+// msg.
+func (c *Client) resendNTLM(hc *http.Client, req *http.Request, resp *http.Response, msg []byte) (*http.Response, error) {
+	req2 := req.Clone(req.Context())
+	if req.GetBody != nil {
+		var err error
+		req2.Body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	req2.Header.Set("Authorization", "NTLM "+base64.StdEncoding.EncodeToString(msg))
+	return c.roundTrip(hc, req2)
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMD4(t *testing.T) {
+	for in, out := range map[string]string{
+		"":    "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"abc": "a448017aaf21d8525fc10ae87aa6729d",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	} {
+		sum := md4Sum([]byte(in))
+		assert.Equal(t, hex.EncodeToString(sum[:]), out)
+	}
+}
+
+// ntlmTargetInfo is the target information of the [MS-NLMP] 4.2.1 examples.
+var ntlmTargetInfo = func() []byte {
+	var b []byte
+	for _, av := range []struct {
+		id uint16
+		s  string
+	}{{2, "Domain"}, {1, "Server"}} {
+		v := utf16le(av.s)
+		b = binary.LittleEndian.AppendUint16(b, av.id)
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(v)))
+		b = append(b, v...)
+	}
+	return append(b, 0, 0, 0, 0)
+}()
+
+func TestNTLMv2Responses(t *testing.T) {
+	// [MS-NLMP] 4.2.4
+	sc, _ := hex.DecodeString("0123456789abcdef")
+	cc, _ := hex.DecodeString("aaaaaaaaaaaaaaaa")
+	lm, nt := ntlmv2Responses("User", "Domain", "Password", sc, cc, make([]byte, 8), ntlmTargetInfo)
+	assert.Equal(t, hex.EncodeToString(lm), "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa")
+	assert.Equal(t, hex.EncodeToString(nt[:16]), "68cd0ab851e51c96aabc927bebef6a1c")
+	assert.Equal(t, nt[16:], append(append([]byte{1, 1, 0, 0, 0, 0, 0, 0}, make([]byte, 8)...), append(append(cc, 0, 0, 0, 0), append(ntlmTargetInfo, 0, 0, 0, 0)...)...))
+}
+
+// ntlmMessage returns the NTLM message in an Authorization header.
+func ntlmMessage(req *http.Request) []byte {
+	h := req.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "NTLM ") {
+		return nil
+	}
+	msg, _ := base64.StdEncoding.DecodeString(h[5:])
+	return msg
+}
+
+// ntlmField returns the payload described by the field at off in msg.
+func ntlmField(msg []byte, off int) []byte {
+	n := int(binary.LittleEndian.Uint16(msg[off:]))
+	p := int(binary.LittleEndian.Uint32(msg[off+4:]))
+	return msg[p : p+n]
+}
+
+func TestNTLMAuth(t *testing.T) {
+	sc, _ := hex.DecodeString("0123456789abcdef")
+	challenge := make([]byte, 48)
+	copy(challenge, ntlmSignature)
+	binary.LittleEndian.PutUint32(challenge[8:], 2)
+	binary.LittleEndian.PutUint32(challenge[20:], ntlmNegotiateFlags|0x00800000)
+	copy(challenge[24:], sc)
+	binary.LittleEndian.PutUint16(challenge[40:], uint16(len(ntlmTargetInfo)))
+	binary.LittleEndian.PutUint32(challenge[44:], uint32(len(challenge)))
+	challenge = append(challenge, ntlmTargetInfo...)
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		msg := ntlmMessage(req)
+		switch {
+		case msg == nil:
+			w.Header().Set("WWW-Authenticate", "NTLM")
+			w.WriteHeader(http.StatusUnauthorized)
+		case binary.LittleEndian.Uint32(msg[8:]) == 1:
+			w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(challenge))
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			user := string(ntlmField(msg, 36))
+			domain := string(ntlmField(msg, 28))
+			nt := ntlmField(msg, 20)
+			// The client challenge and timestamp are taken from the response
+			_, want := ntlmv2Responses("User", "Domain", "Password", sc, nt[32:40], nt[24:32], ntlmTargetInfo)
+			if user != string(utf16le("User")) || domain != string(utf16le("Domain")) || !bytes.Equal(nt, want) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			HandlePost(w, req)
+		}
+	}))
+	defer srv.Close()
+	client := New()
+	client.Now = func() time.Time { return time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC) }
+	r := RequestResponse{
+		Url:        srv.URL,
+		Method:     POST,
+		Userinfo:   url.UserPassword(`Domain\User`, "Password"),
+		AuthScheme: NTLMAuth,
+		Data:       fooStruct,
+		Result:     new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, hits, int32(3))
+	assert.Equal(t, r.Result, &barStruct)
+	//
+	// Other schemes don't answer the challenge
+	//
+	hits = 0
+	r.AuthScheme = DigestAuth
+	status, _ = client.Do(&r)
+	assert.Equal(t, status, 401)
+	assert.Equal(t, hits, int32(1))
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"net/http"
+	"time"
+)
+
+// An Option configures a Client made by New.  Options are applied in order,
+// and each sets fields which may equally be set directly.
+type Option func(*Client)
+
+// WithHttpClient makes the client send requests with hc.  It should come
+// before options configuring hc, such as WithTimeout.
+func WithHttpClient(hc *http.Client) Option {
+	return func(c *Client) { c.HttpClient = hc }
+}
+
+// WithBaseURL sets the client's BaseURL.
+func WithBaseURL(u string) Option {
+	return func(c *Client) { c.BaseURL = u }
+}
+
+// WithTimeout sets the Timeout of the client's HttpClient, limiting every
+// request, including reading its response.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.HttpClient.Timeout = d }
+}
+
+// WithHeader adds a header to the client's DefaultHeaders.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		if c.DefaultHeaders == nil {
+			c.DefaultHeaders = http.Header{}
+		}
+		c.DefaultHeaders.Add(key, value)
+	}
+}
+
+// WithDefaultError sets the client's DefaultError, e.g.
+// WithDefaultError(&APIError{}).
+func WithDefaultError(v interface{}) Option {
+	return func(c *Client) { c.DefaultError = v }
+}
+
+// WithLogger sets the client's Logger.
+func WithLogger(l Logger) Option {
+	return func(c *Client) { c.Logger = l }
+}
+
+// WithRetries sets the client's Retries and Backoff; a nil backoff means
+// DefaultBackoff.
+func WithRetries(n int, backoff func(attempt int) time.Duration) Option {
+	return func(c *Client) {
+		c.Retries = n
+		c.Backoff = backoff
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	client := New(
+		WithBaseURL(srv.URL),
+		WithTimeout(5*time.Second),
+		WithHeader("X-Foo", "bar"),
+		WithDefaultError(&errorStruct{}),
+		WithRetries(2, nil),
+	)
+	assert.Equal(t, client.BaseURL, srv.URL)
+	assert.Equal(t, client.HttpClient.Timeout, 5*time.Second)
+	assert.Equal(t, client.DefaultHeaders.Get("X-Foo"), "bar")
+	assert.Equal(t, client.Retries, 2)
+	//
+	// Error responses are unmarshalled into a new DefaultError
+	//
+	client.Retries = 0
+	r := RequestResponse{Url: "spam", Method: GET}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 500)
+	e, ok := r.Error.(*errorStruct)
+	assert.T(t, ok, r.Error)
+	assert.Equal(t, e.Message, "Bad query params: ")
+	assert.Equal(t, *client.DefaultError.(*errorStruct), errorStruct{})
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// EachPage executes r and calls fn with the result, then repeats the request
+// against the URL returned by next, until next returns "" or fn returns
+// false.  The URL returned by next replaces r.Url, and is expected to carry
+// all query parameters for the following page, so Params, Query and
+// QueryStruct are cleared, as are PathParams.  Each page is unmarshalled into
+// the same r.Result.
+//
+// Iteration also stops, returning an error, if a request fails, if a page
+// has a non-2xx status, or if r.Context is done.
+func (c *Client) EachPage(r *RequestResponse, next func(*RequestResponse) string, fn func(*RequestResponse) bool) error {
+	for {
+		status, err := c.Do(r)
+		if err != nil {
+			return err
+		}
+		if status < 200 || status >= 300 {
+			return c.statusError(r, status)
+		}
+		if !fn(r) {
+			return nil
+		}
+		u := next(r)
+		if u == "" {
+			return nil
+		}
+		if r.Context != nil && r.Context.Err() != nil {
+			return r.Context.Err()
+		}
+		r.Url = u
+		r.Params = nil
+		r.PathParams = nil
+		r.Query = nil
+		r.QueryStruct = nil
+	}
+}
+
+// NextLink returns the URL of the next page given by the Link header of r's
+// response, for use with EachPage.
+func NextLink(r *RequestResponse) string {
+	return r.Links["next"]
+}
+
+// parseLinks parses the Link headers in h, as per RFC 8288, returning the
+// URL of each link keyed by relation type.  Relative URLs are resolved
+// against base, if it is not nil.  Where several links share a relation
+// type the first wins.
+func parseLinks(h http.Header, base *url.URL) map[string]string {
+	var links map[string]string
+	for _, s := range h.Values("Link") {
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if !strings.HasPrefix(s, "<") {
+				break
+			}
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				break
+			}
+			target := s[1:end]
+			s = s[end+1:]
+			var rels []string
+			for {
+				s = strings.TrimLeft(s, " \t")
+				if !strings.HasPrefix(s, ";") {
+					break
+				}
+				var name, value string
+				name, value, s = parseParam(s[1:])
+				if strings.EqualFold(name, "rel") && rels == nil {
+					rels = strings.Fields(value)
+				}
+			}
+			if u, err := url.Parse(target); err == nil && base != nil {
+				target = base.ResolveReference(u).String()
+			}
+			for _, rel := range rels {
+				rel = strings.ToLower(rel)
+				if links == nil {
+					links = make(map[string]string)
+				}
+				if _, ok := links[rel]; !ok {
+					links[rel] = target
+				}
+			}
+		}
+	}
+	return links
+}
+
+// parseParam parses a header parameter at the start of s, such as
+// rel="next", returning its name and value, and the remainder of s.
+func parseParam(s string) (name, value, rest string) {
+	s = strings.TrimLeft(s, " \t")
+	i := strings.IndexAny(s, "=;,")
+	if i < 0 || s[i] != '=' {
+		if i < 0 {
+			i = len(s)
+		}
+		return strings.TrimSpace(s[:i]), "", s[i:]
+	}
+	name = strings.TrimSpace(s[:i])
+	s = strings.TrimLeft(s[i+1:], " \t")
+	if !strings.HasPrefix(s, `"`) {
+		i = strings.IndexAny(s, ";,")
+		if i < 0 {
+			i = len(s)
+		}
+		return name, strings.TrimSpace(s[:i]), s[i:]
+	}
+	var b strings.Builder
+	for i = 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return name, b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return name, b.String(), ""
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type page struct {
+	Items []int
+	Next  string
+}
+
+// HandlePages serves three pages of two items each.
+func HandlePages(w http.ResponseWriter, req *http.Request) {
+	n, _ := strconv.Atoi(req.URL.Query().Get("page"))
+	p := page{Items: []int{2 * n, 2*n + 1}}
+	if n < 2 {
+		p.Next = "http://" + req.Host + "/?page=" + strconv.Itoa(n+1)
+	}
+	blob, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(blob)
+}
+
+func nextPage(r *RequestResponse) string {
+	return r.Result.(*page).Next
+}
+
+func TestEachPage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePages))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Params: map[string]string{"page": "0"},
+		Result: new(page),
+	}
+	var items []int
+	err := client.EachPage(&r, nextPage, func(r *RequestResponse) bool {
+		items = append(items, r.Result.(*page).Items...)
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, items, []int{0, 1, 2, 3, 4, 5})
+}
+
+func TestEachPagePathParams(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		paths = append(paths, req.URL.Path)
+		mu.Unlock()
+		HandleLinkPages(w, req)
+	}))
+	defer srv.Close()
+	client := New()
+	var res []int
+	r := RequestResponse{
+		Url:        srv.URL + "/lists/{id}",
+		Method:     GET,
+		PathParams: map[string]string{"id": "7"},
+		Result:     &res,
+	}
+	var items []int
+	err := client.EachPage(&r, NextLink, func(r *RequestResponse) bool {
+		items = append(items, res...)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, items, []int{0, 1, 2, 3, 4, 5})
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, paths, []string{"/lists/7", "/", "/"})
+}
+
+func TestEachPageQueryStruct(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		queries = append(queries, req.URL.RawQuery)
+		mu.Unlock()
+		// Next links carry the page size
+		n, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		if n < 2 {
+			w.Header().Set("Link", `</?page=`+strconv.Itoa(n+1)+`&per=50>; rel="next"`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		QueryStruct: struct {
+			Per int `url:"per"`
+		}{50},
+		Result: new([]int),
+	}
+	err := client.EachPage(&r, NextLink, func(r *RequestResponse) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, queries, []string{"per=50", "page=1&per=50", "page=2&per=50"})
+}
+
+func TestEachPageStop(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePages))
+	defer srv.Close()
+	client := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := RequestResponse{
+		Url:     srv.URL,
+		Method:  GET,
+		Result:  new(page),
+		Context: ctx,
+	}
+	pages := 0
+	err := client.EachPage(&r, nextPage, func(r *RequestResponse) bool {
+		pages++
+		return pages < 2
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pages, 2)
+	//
+	// Cancelling the context stops iteration between pages
+	//
+	r.Url = srv.URL
+	pages = 0
+	err = client.EachPage(&r, nextPage, func(r *RequestResponse) bool {
+		pages++
+		cancel()
+		return true
+	})
+	assert.Equal(t, err, context.Canceled)
+	assert.Equal(t, pages, 1)
+}
+
+func TestParseLinks(t *testing.T) {
+	base, _ := url.Parse("https://api.github.com/repos/foo/bar/issues?page=2")
+	h := http.Header{}
+	h.Add("Link", `<https://api.github.com/repositories/1/issues?page=3>; rel="next", `+
+		`<https://api.github.com/repositories/1/issues?page=5>; rel="last"`)
+	h.Add("Link", `</issues?page=1>; title="first, really; yes"; rel="first prev", <ignored>; rel=next`)
+	assert.Equal(t, parseLinks(h, base), map[string]string{
+		"next":  "https://api.github.com/repositories/1/issues?page=3",
+		"last":  "https://api.github.com/repositories/1/issues?page=5",
+		"first": "https://api.github.com/issues?page=1",
+		"prev":  "https://api.github.com/issues?page=1",
+	})
+	assert.Equal(t, len(parseLinks(http.Header{}, base)), 0)
+}
+
+// HandleLinkPages serves three pages of two items each, linked by the Link
+// header.
+func HandleLinkPages(w http.ResponseWriter, req *http.Request) {
+	n, _ := strconv.Atoi(req.URL.Query().Get("page"))
+	if n < 2 {
+		w.Header().Set("Link", `</?page=`+strconv.Itoa(n+1)+`>; rel="next"`)
+	}
+	blob, _ := json.Marshal([]int{2 * n, 2*n + 1})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(blob)
+}
+
+func TestEachPageLinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleLinkPages))
+	defer srv.Close()
+	client := New()
+	var res []int
+	r := RequestResponse{Url: srv.URL, Method: GET, Result: &res}
+	var items []int
+	err := client.EachPage(&r, NextLink, func(r *RequestResponse) bool {
+		items = append(items, res...)
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, items, []int{0, 1, 2, 3, 4, 5})
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPooledBuffersNotShared(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.URL.Query().Get("body")))
+	}))
+	defer srv.Close()
+	c := New()
+	first := strings.Repeat("a", 1000)
+	r0 := RequestResponse{Url: srv.URL, Method: GET, Params: map[string]string{"body": first}}
+	_, err := c.Do(&r0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1 := RequestResponse{Url: srv.URL, Method: GET, Params: map[string]string{"body": "bbbb"}}
+	_, err = c.Do(&r1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, r0.RawText, first)
+	assert.Equal(t, r1.RawText, "bbbb")
+}
+
+func TestPooledBuffersNotRetained(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`"` + req.URL.Query().Get("body") + `"`))
+	}))
+	defer srv.Close()
+	var validated, unmarshalled []byte
+	c := New()
+	c.Unmarshal = func(data []byte, v interface{}) error {
+		unmarshalled = data
+		return nil
+	}
+	r := RequestResponse{
+		Url:      srv.URL,
+		Method:   GET,
+		Params:   map[string]string{"body": "first"},
+		Validate: func(raw []byte) error { validated = raw; return nil },
+	}
+	_, err := c.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = RequestResponse{Url: srv.URL, Method: GET, Params: map[string]string{"body": "SECOND"}}
+	_, err = New().Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(validated), `"first"`)
+	assert.Equal(t, string(unmarshalled), `"first"`)
+}
+
+func BenchmarkDo(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePost))
+	defer srv.Close()
+	c := New()
+	c.Logger = log.New(ioutil.Discard, "", 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := RequestResponse{
+			Url:    srv.URL,
+			Method: POST,
+			Data:   &fooStruct,
+			Result: new(structType),
+		}
+		_, err := c.Do(&r)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
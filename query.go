@@ -0,0 +1,130 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encodeQuery adds the fields of v, a struct or pointer to one, to vals as
+// query parameters.  Each field is named by its url tag, or else its Go
+// name; a tag of "-" omits it, and the omitempty option omits it when it
+// has its zero value.  Slices and arrays become repeated parameters, nil
+// pointers are omitted, embedded structs are flattened, and time.Times are
+// formatted as RFC 3339 unless a layout tag gives another layout.
+func encodeQuery(vals url.Values, v interface{}) error {
+	return encodeQueryValue(vals, reflect.ValueOf(v))
+}
+
+func encodeQueryValue(vals url.Values, rv reflect.Value) error {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errors.New("QueryStruct must be a struct or a pointer to one")
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			// Unexported
+			continue
+		}
+		tag := sf.Tag.Get("url")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		f := rv.Field(i)
+		if sf.Anonymous && name == "" && indirectType(sf.Type).Kind() == reflect.Struct &&
+			indirectType(sf.Type) != timeType {
+			err := encodeQueryValue(vals, f)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if opts == "omitempty" && f.IsZero() {
+			continue
+		}
+		for f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				break
+			}
+			f = f.Elem()
+		}
+		if f.Kind() == reflect.Ptr {
+			continue
+		}
+		layout := sf.Tag.Get("layout")
+		if (f.Kind() == reflect.Slice || f.Kind() == reflect.Array) && f.Type().Elem().Kind() != reflect.Uint8 {
+			for j := 0; j < f.Len(); j++ {
+				s, err := queryValue(f.Index(j), layout)
+				if err != nil {
+					return errors.New("Cannot encode query field " + sf.Name + ": " + err.Error())
+				}
+				vals.Add(name, s)
+			}
+			continue
+		}
+		s, err := queryValue(f, layout)
+		if err != nil {
+			return errors.New("Cannot encode query field " + sf.Name + ": " + err.Error())
+		}
+		vals.Add(name, s)
+	}
+	return nil
+}
+
+// indirectType returns the type pointed to by t, or t if it is not a
+// pointer.
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// queryValue formats f as a query parameter value.
+func queryValue(f reflect.Value, layout string) (string, error) {
+	if f.Type() == timeType {
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		if !f.CanInterface() {
+			return "", errors.New("Cannot read time in unexported struct")
+		}
+		return f.Interface().(time.Time).Format(layout), nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		return f.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(f.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(f.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(f.Float(), 'f', -1, f.Type().Bits()), nil
+	case reflect.Slice:
+		if f.Type().Elem().Kind() == reflect.Uint8 {
+			return string(f.Bytes()), nil
+		}
+	}
+	return "", errors.New("Unsupported type " + f.Type().String())
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type paging struct {
+	Page    int `url:"page,omitempty"`
+	PerPage int `url:"per_page,omitempty"`
+}
+
+type searchQuery struct {
+	paging
+	Q       string    `url:"q"`
+	Limit   uint8     `url:"limit"`
+	Ratio   float64   `url:"ratio,omitempty"`
+	Draft   bool      `url:"draft"`
+	Tags    []string  `url:"tag"`
+	IDs     [2]int    `url:"id"`
+	Since   time.Time `url:"since"`
+	Day     time.Time `url:"day,omitempty" layout:"2006-01-02"`
+	Owner   *string   `url:"owner"`
+	Secret  string    `url:"-"`
+	Untaged string
+	ignored string
+}
+
+func TestEncodeQuery(t *testing.T) {
+	owner := "jason"
+	since := time.Date(2013, 4, 5, 6, 7, 8, 0, time.UTC)
+	q := searchQuery{
+		paging:  paging{Page: 2},
+		Q:       "foo bar",
+		Limit:   10,
+		Draft:   true,
+		Tags:    []string{"a", "b"},
+		IDs:     [2]int{7, 8},
+		Since:   since,
+		Day:     since,
+		Owner:   &owner,
+		Secret:  "xyzzy",
+		Untaged: "yes",
+		ignored: "no",
+	}
+	vals := url.Values{}
+	err := encodeQuery(vals, &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := url.Values{
+		"page":    {"2"},
+		"q":       {"foo bar"},
+		"limit":   {"10"},
+		"draft":   {"true"},
+		"tag":     {"a", "b"},
+		"id":      {"7", "8"},
+		"since":   {"2013-04-05T06:07:08Z"},
+		"day":     {"2013-04-05"},
+		"owner":   {"jason"},
+		"Untaged": {"yes"},
+	}
+	assert.Equal(t, vals, expected)
+	//
+	// Zero values
+	//
+	vals = url.Values{}
+	err = encodeQuery(vals, searchQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = url.Values{
+		"q":       {""},
+		"limit":   {"0"},
+		"draft":   {"false"},
+		"id":      {"0", "0"},
+		"since":   {"0001-01-01T00:00:00Z"},
+		"Untaged": {""},
+	}
+	assert.Equal(t, vals, expected)
+	//
+	// Unsupported
+	//
+	err = encodeQuery(url.Values{}, 42)
+	assert.NotEqual(t, err, nil)
+	err = encodeQuery(url.Values{}, struct{ M map[string]int }{})
+	assert.NotEqual(t, err, nil)
+}
+
+func TestQueryStruct(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query = req.URL.Query()
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    srv.URL + "?q=baz",
+		Method: GET,
+		Params: fooMap,
+		QueryStruct: &searchQuery{
+			Q:    "qux",
+			Tags: []string{"x"},
+		},
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Result, &barStruct)
+	assert.Equal(t, query["foo"], []string{"bar"})
+	assert.Equal(t, query["q"], []string{"baz", "qux"})
+	assert.Equal(t, query["tag"], []string{"x"})
+	//
+	// Encoding errors are returned before anything is sent
+	//
+	query = nil
+	r = RequestResponse{
+		Url:         srv.URL,
+		Method:      GET,
+		QueryStruct: "nope",
+	}
+	_, err = client.Do(&r)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, query, url.Values(nil))
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A RateLimiter throttles the requests made by a Client.  Wait blocks until
+// a request may be sent, returning an error if ctx is done first.  It is
+// satisfied by *rate.Limiter from golang.org/x/time/rate.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// NewRateLimiter returns a RateLimiter which spaces requests evenly, allowing
+// at most perSecond of them each second.  It is safe for concurrent use.
+func NewRateLimiter(perSecond float64) RateLimiter {
+	return &intervalLimiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+	}
+}
+
+type intervalLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time // Earliest time at which the next request may be sent
+}
+
+func (l *intervalLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	t := l.next
+	if t.Before(now) {
+		t = now
+	}
+	l.next = t.Add(l.interval)
+	l.mu.Unlock()
+	if !sleep(ctx, time.Until(t)) {
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"context"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	client := New()
+	client.RateLimiter = NewRateLimiter(20)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		r := RequestResponse{
+			Url:    srv.URL,
+			Method: GET,
+			Params: fooMap,
+		}
+		_, err := client.Do(&r)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	// Requests are spaced 50ms apart, the first being sent immediately.
+	assert.T(t, time.Since(start) >= 150*time.Millisecond)
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	client.RateLimiter = NewRateLimiter(0.001)
+	client.RateLimiter.Wait(context.Background())
+	r := RequestResponse{
+		Url:     "http://localhost:0",
+		Method:  GET,
+		Timeout: 50 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err := client.Do(&r)
+	assert.Equal(t, err, context.DeadlineExceeded)
+	assert.T(t, time.Since(start) < time.Second)
+}
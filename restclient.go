@@ -35,26 +35,66 @@ Example:
 package restclient
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// Version is the version of this package.
+const Version = "1.0"
+
+// DefaultUserAgent is sent as the User-Agent header by clients which don't
+// set their own.
+const DefaultUserAgent = "restclient/" + Version
+
 // A Method is an HTTP verb.
 type Method string
 
 var (
-	GET    = Method("GET")
-	PUT    = Method("PUT")
-	POST   = Method("POST")
-	DELETE = Method("DELETE")
+	GET     = Method("GET")
+	PUT     = Method("PUT")
+	POST    = Method("POST")
+	DELETE  = Method("DELETE")
+	PATCH   = Method("PATCH")
+	HEAD    = Method("HEAD")
+	OPTIONS = Method("OPTIONS")
+	TRACE   = Method("TRACE")   // Can't have a body
+	CONNECT = Method("CONNECT") // Do discards the tunnel; use DoStream to keep it
+)
+
+// A PatchType is the content type of a JSON patch document.
+type PatchType string
+
+const (
+	MergePatch PatchType = "application/merge-patch+json" // RFC 7396
+	JSONPatch  PatchType = "application/json-patch+json"  // RFC 6902
+)
+
+// An AuthScheme is a way of authenticating with the credentials in
+// Userinfo.
+type AuthScheme int
+
+const (
+	BasicAuth  AuthScheme = iota // Send the credentials with each request
+	DigestAuth                   // Answer a 401 Digest challenge, resending the request
+	NTLMAuth                     // Answer a 401 NTLM challenge with the NTLM handshake; the username may be "DOMAIN\user"
 )
 
 // A RequestResponse describes an HTTP request to be executed, data
@@ -63,160 +103,1146 @@ var (
 // response we allow easy access to Result and Error objects without needing
 // type assertions.
 type RequestResponse struct {
-	Url      string            // Raw URL string
-	Method   Method            // HTTP method to use 
-	Userinfo *url.Userinfo     // Optional username/password to authenticate this request
-	Params   map[string]string // URL parameters for GET requests (ignored otherwise)
-	Headers  *http.Header      // HTTP Headers to use (will override defaults)
+	Url           string            // Raw URL string
+	PathParams    map[string]string // Values, escaped, for {name} placeholders in Url
+	Method        Method            // HTTP method to use
+	Userinfo      *url.Userinfo     // Optional username/password to authenticate this request
+	AuthScheme    AuthScheme        // How Userinfo is used to authenticate
+	BearerToken   string            // Optional token to authenticate this request (exclusive with Userinfo)
+	Params        map[string]string // URL query parameters
+	Query         url.Values        // URL query parameters, possibly repeated, added to Params
+	QueryStruct   interface{}       // Struct whose fields, named by url tags, are added to the query parameters
+	Headers       *http.Header      // HTTP Headers to use (will override defaults; a header with no values, as in http.Header{"Accept": {}}, removes a default)
+	Context       context.Context   // Optional context to cancel or time out this request
+	Timeout       time.Duration     // Optional time limit for this request, further constraining Context
+	FormData      url.Values        // Data to form-encode and POST (exclusive with Data)
+	Multipart     *Multipart        // Fields and files to POST as multipart/form-data (exclusive with Data)
+	Files         map[string]string // Paths of local files to upload, keyed by form field, added to Multipart
+	Body          io.Reader         // Body to stream as-is, unbuffered and never retried, with the Content-Type given in Headers (exclusive with Data)
+	ContentLength int64             // Length of Body, sent as Content-Length; if zero, Body is sent chunked unless it is a *bytes.Buffer, *bytes.Reader or *strings.Reader
+	Compress      bool              // Gzip the request body (not applied to Multipart or Body)
+	PatchType     PatchType         // Content type of a JSON patch document in Data, for PATCH requests
+	ErrorOnStatus bool              // Return a *StatusError for a non-2xx response, as if set on the Client
+	//
+	// If set, HttpClient sends this request in place of the Client's own
+	// HttpClient, e.g. to use a different proxy or TLS configuration.  Its
+	// Timeout, Transport, Jar and CheckRedirect all apply instead of the
+	// Client's; everything else configured on the Client still applies.
+	//
+	HttpClient *http.Client
+	//
+	// IdempotencyKey is sent as the Idempotency-Key header, so the server
+	// can recognise retries of the same operation.  If the client has
+	// GenerateIdempotencyKeys set, a key is generated for retried POST and
+	// PATCH requests which lack one, and is reused by every attempt.
+	//
+	IdempotencyKey string
+	//
+	// Conditional request headers.  If a request with IfMatch set fails with
+	// status 412, Do returns ErrPreconditionFailed.
+	//
+	IfMatch         string
+	IfNoneMatch     string
+	IfModifiedSince time.Time
 	//
 	// The following interfaces fields should be populated with *pointers* to
-	// data structures.  Any structure that can be (un)marshalled by the json
-	// package can be used.
+	// data structures.  Any structure that can be (un)marshalled by the
+	// client's Encoding can be used.  Data may also be a []byte or
+	// io.Reader, which is sent as-is with whatever Content-Type is given in
+	// Headers.  Result and Error may be *json.RawMessages, to capture a JSON
+	// body undecoded.
 	//
-	Data   interface{} // Data to JSON-encode and POST
+	Data   interface{} // Data to encode as the request body, with any method (including DELETE)
 	Result interface{} // Successful response is unmarshalled into Result
 	Error  interface{} // Error response is unmarshalled into Error
 	//
-	// The following fields are populated by Client.Do()
+	// If ResultField is set, only the field found at that dot-separated path
+	// within a JSON response - e.g. "data.items" - is unmarshalled into Result.
+	//
+	ResultField string
+	//
+	// If HeaderResult is set, to a pointer to a struct, response headers are
+	// unmarshalled into its fields tagged with their names, as in
+	// `header:"X-Total-Count"`.  Fields may be strings, []strings, bools,
+	// numbers, time.Durations (in seconds) or time.Times (HTTP dates or RFC
+	// 3339); those whose headers are missing, and unexported fields, are
+	// left untouched.
+	//
+	HeaderResult interface{}
+	//
+	// If Stream is set, a successful response is decoded directly from the
+	// network as it arrives, and RawText is left empty.  This saves memory
+	// when fetching large responses.
+	//
+	Stream bool
+	//
+	// If Validate is set, it is called with the raw body of a successful
+	// response before it is decoded into Result, e.g. to check it against a
+	// JSON Schema; if it returns an error, Do returns that error and Result
+	// is left untouched.  Responses are buffered, even with Stream set.
+	//
+	Validate func(raw []byte) error
 	//
-	Timestamp time.Time // Time when HTTP request was sent
-	RawText   string    // Raw text of server response (JSON or otherwise)
-	Status    int       // HTTP status for executed request
+	// If Output is set, the body of a successful response is copied into
+	// it as it arrives, e.g. to download into a file, and is neither kept in
+	// RawText nor decoded into Result.  Error responses are decoded as usual.
+	//
+	Output io.Writer
+	//
+	// If set, PrepareRequest is called with the HTTP request once it is
+	// built - its URL, headers and body already set - and before it is
+	// signed and sent, on every attempt.  It may change the request as it
+	// likes, e.g. to add trailers or headers with unusual casing; if it
+	// returns an error, the request is not sent and Do returns that error.
+	//
+	PrepareRequest func(*http.Request) error
+	//
+	// The following fields are populated by Client.Do(); DoStream populates
+	// only SentURL, Timestamp, Status, Header, RetryAfter, Links and
+	// FromCache.  If Do fails after a response has arrived, they describe as
+	// much of it as was received - RawText may hold a truncated body.
+	//
+	SentURL    *url.URL          // URL the request was sent to, after resolution against BaseURL and adding Params and Query
+	Timestamp  time.Time         // Time when HTTP request was sent
+	RawText    string            // Raw text of server response (JSON or otherwise)
+	Status     int               // HTTP status for executed request
+	Header     http.Header       // Headers returned by the server
+	RetryAfter time.Duration     // Delay requested by a Retry-After header on a 429 or 503 response
+	Duration   time.Duration     // Time taken to send the request and read the response, including retries
+	Raw        interface{}       // Generic decoding of a response which didn't fit Result or Error
+	Links      map[string]string // URLs given by the Link header, keyed by relation type, e.g. "next"
+	FromCache  bool              // Response was served from the client's Cache after a 304 Not Modified
+	//
+	// RequestBody is the encoded request body, before any compression, if
+	// the client has RecordRequestBody set.  Multipart bodies are streamed,
+	// and so not recorded.
+	//
+	RequestBody []byte
+	//
+	// HasBody reports whether the response had a non-empty body.  If not,
+	// as with 204 No Content, RawText is empty and Result and Error are left
+	// untouched; whereas a JSON body of null sets them to nil.
+	//
+	HasBody bool
+}
+
+// IsSuccess reports whether the response status is 2xx.
+func (r *RequestResponse) IsSuccess() bool {
+	return r.Status >= 200 && r.Status < 300
 }
 
-// Client is a REST client.
+// IsRedirect reports whether the response status is 3xx.
+func (r *RequestResponse) IsRedirect() bool {
+	return r.Status >= 300 && r.Status < 400
+}
+
+// IsClientError reports whether the response status is 4xx.
+func (r *RequestResponse) IsClientError() bool {
+	return r.Status >= 400 && r.Status < 500
+}
+
+// IsServerError reports whether the response status is 5xx.
+func (r *RequestResponse) IsServerError() bool {
+	return r.Status >= 500 && r.Status < 600
+}
+
+// Clone returns a copy of r which can be modified, and sent concurrently
+// with r, without affecting it.  The maps Params, PathParams, Query,
+// FormData, Files and Headers are copied; everything else, including the
+// Data, Result and Error pointers, is shared, so give the clone its own
+// Result and Error before sending both at once.
+func (r *RequestResponse) Clone() *RequestResponse {
+	r2 := *r
+	r2.Params = copyMap(r.Params)
+	r2.PathParams = copyMap(r.PathParams)
+	r2.Files = copyMap(r.Files)
+	r2.Query = copyValues(r.Query)
+	r2.FormData = copyValues(r.FormData)
+	if r.Headers != nil {
+		h := r.Headers.Clone()
+		r2.Headers = &h
+	}
+	return &r2
+}
+
+// copyMap returns a copy of m.
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	m2 := make(map[string]string, len(m))
+	for k, v := range m {
+		m2[k] = v
+	}
+	return m2
+}
+
+// copyValues returns a copy of vals.
+func copyValues(vals url.Values) url.Values {
+	if vals == nil {
+		return nil
+	}
+	vals2 := make(url.Values, len(vals))
+	for k, vv := range vals {
+		vals2[k] = append([]string(nil), vv...)
+	}
+	return vals2
+}
+
+// A Logger receives the diagnostic messages written by a Client.  It is
+// satisfied by *log.Logger; supply log.New(ioutil.Discard, "", 0) to silence
+// the client entirely.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Client is a REST client.  Once configured, a Client is safe for concurrent
+// use by multiple goroutines, but its fields must not be changed, nor methods
+// such as Use and SetProxy called, while requests are in flight.  Hooks such
+// as Logger, OnRequest, Tracer, Metrics, Signer, Cache and TokenSource may be
+// called concurrently.  A RequestResponse belongs to one request at a time.
 type Client struct {
 	HttpClient      *http.Client
-	UnsafeBasicAuth bool // Allow Basic Auth over unencrypted HTTP
+	UnsafeBasicAuth bool     // Allow Basic Auth over unencrypted HTTP
+	Logger          Logger   // Destination for diagnostic messages; nil means the standard logger
+	Encoding        Encoding // Encodes request bodies, and decodes responses of its types (see MediaTyper) or with none; nil means JSON
+	ForceEncoding   bool     // Decode every response with Encoding, whatever its Content-Type, e.g. if the server mislabels it
+	//
+	// If set, Marshal and Unmarshal are used in place of encoding/json
+	// wherever JSON is encoded or decoded, e.g. to plug in a faster library.
+	//
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+	//
+	// Relative request URLs are resolved against BaseURL, as per RFC 3986.
+	// A trailing slash is implied, so "users" resolves against
+	// "http://foo.com/api" to "http://foo.com/api/users", but "/users" to
+	// "http://foo.com/users".
+	//
+	BaseURL           string
+	DefaultHeaders    http.Header // Headers sent with every request, unless overridden by its Headers
+	UserAgent         string      // User-Agent header for every request; defaults to DefaultUserAgent
+	ErrorOnStatus     bool        // Return a *StatusError for any non-2xx response
+	StrictDecode      bool        // Return a *DecodeError, rather than decoding into Raw, if Result or Error don't fit
+	UseNumber         bool        // Decode JSON numbers into interface{} values, such as Raw, as json.Numbers, preserving large integers
+	RecordRequestBody bool        // Keep each request's encoded body in its RequestBody, e.g. for audit logging
+	//
+	// If DisallowUnknownFields is set, a JSON response with a field which
+	// Result's struct lacks is a *DecodeError, as if in StrictDecode mode,
+	// e.g. to catch schema drift in tests.  Error is decoded as usual.
+	//
+	DisallowUnknownFields bool
+	//
+	// If set, to a pointer, error responses to requests without an Error are
+	// unmarshalled into a new value of the type it points to, which is
+	// stored in their Error field.
+	//
+	DefaultError interface{}
+	//
+	// The message of a *StatusError includes up to ErrorBodyBytes bytes of
+	// the response body - 512 if zero, or none if negative - which are
+	// first passed through RedactErrorBody, if set, e.g. to mask secrets.
+	//
+	ErrorBodyBytes  int
+	RedactErrorBody func(body string) string
+	//
+	// Response bodies are converted to UTF-8 from the charset given by their
+	// Content-Type.  ISO-8859-1 is supported out of the box; if set,
+	// CharsetReader converts others, as does charset.NewReaderLabel from
+	// golang.org/x/net/html/charset.  Bodies in other charsets are left as
+	// they are.
+	//
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+	//
+	// If greater than zero, MaxResponseBytes limits the length of response
+	// bodies read by Do, after decompression, and MaxRequestBytes that of
+	// encoded request bodies other than Multipart, and the ContentLength of
+	// a Body.  Longer bodies fail with ErrResponseTooLarge or
+	// ErrRequestTooLarge.
+	//
+	MaxResponseBytes int64
+	MaxRequestBytes  int64
+	//
+	// If set, OnRequest is called with each HTTP request just before it is
+	// sent, including retries, and OnResponse with each RequestResponse
+	// once its response has been decoded.
+	//
+	OnRequest  func(*http.Request)
+	OnResponse func(*RequestResponse)
+	Tracer     Tracer  // Optional tracer recording a span for each HTTP request
+	Metrics    Metrics // Optional recorder of metrics for each request
+	//
+	// If set, RateLimiter is waited on before each HTTP request is sent.
+	//
+	RateLimiter    RateLimiter
+	CircuitBreaker *CircuitBreaker // Optional breaker to fail fast while a server is down
+	Signer         Signer          // Optional signer of each HTTP request
+	Cache          Cache           // Optional cache of GET responses, revalidated by ETag
+	//
+	// If set, TokenSource supplies a Bearer token for each request which
+	// has no other credentials or Authorization header.
+	//
+	TokenSource TokenSource
+	//
+	// Transient failures - connection errors, 429 and 5xx responses - are
+	// retried up to Retries times, waiting Backoff(attempt) between attempts,
+	// or as long as the server's Retry-After header asks.  A nil Backoff
+	// means DefaultBackoff; FullJitterBackoff and DecorrelatedJitterBackoff
+	// make others, and ExponentialBackoff waits without jitter.  The
+	// request's Context and Timeout bound the whole sequence of attempts:
+	// once the deadline would pass before the next attempt, Do gives up,
+	// returning an error wrapping both context.DeadlineExceeded and the last
+	// attempt's failure.  POST and PATCH requests are not idempotent, and are
+	// only retried if RetryPost is set.
+	//
+	Retries                 int
+	Backoff                 func(attempt int) time.Duration
+	RetryPost               bool
+	GenerateIdempotencyKeys bool
+	//
+	// If set, RetryableStatus and RetryableError decide which responses and
+	// transport errors are transient, and so retried.  By default responses
+	// are as per DefaultRetryableStatus, and every error is, including
+	// timeouts and connection resets.
+	//
+	RetryableStatus func(status int) bool
+	RetryableError  func(err error) bool
+	//
+	// Now returns the current time, used to stamp requests, measure their
+	// Duration and compute waits for Retry-After dates; nil means time.Now.
+	// Tests may set it to control the clock.  Context deadlines, including
+	// those set by Timeout, always follow the real clock.
+	//
+	Now func() time.Time
+
+	middleware []Middleware // Installed by Use
 }
 
-// New returns a new Client instance.
-func New() *Client {
-	return &Client{
+// New returns a new Client instance, configured by opts.
+func New(opts ...Option) *Client {
+	c := &Client{
 		HttpClient:      new(http.Client),
 		UnsafeBasicAuth: false,
+		Logger:          log.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// EnableCookies gives the client a cookie jar, so cookies set by a server
+// are sent with later requests to it.  The jar is safe for concurrent use,
+// but is shared by every request made with this client; use separate clients
+// for separate sessions.
+func (c *Client) EnableCookies() error {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
 	}
+	c.HttpClient.Jar = jar
+	return nil
 }
 
-// Do executes a REST request.
+// Do executes a REST request, passing it through any middleware installed
+// with Use.
 func (c *Client) Do(r *RequestResponse) (status int, err error) {
+	return c.handler()(r)
+}
+
+// do executes a REST request.
+func (c *Client) do(r *RequestResponse) (status int, err error) {
+	if c.Metrics != nil {
+		defer c.observe(r)
+	}
+	r.RawText, r.Raw, r.HasBody = "", nil, false
+	resp, err := c.send(r)
+	if err != nil {
+		// The status of the last response, if a retry was abandoned
+		status = r.Status
+		return
+	}
+	defer resp.Body.Close()
+	if c.OnResponse != nil {
+		defer c.OnResponse(r)
+	}
+	status = resp.StatusCode
+	if r.HeaderResult != nil {
+		err = decodeHeaders(resp.Header, r.HeaderResult)
+		if err != nil {
+			r.Duration = c.now().Sub(r.Timestamp)
+			c.complain(err, status, "")
+			err = &DecodeError{Status: status, Err: err}
+			return
+		}
+	}
+	if r.Method == CONNECT && status >= 200 && status < 300 {
+		// The body is the tunnel, which is closed unread
+		r.Duration = c.now().Sub(r.Timestamp)
+		return
+	}
+	var rd io.Reader
+	rd, err = decompress(resp)
+	if err == nil {
+		rd, err = c.transcode(resp, rd)
+	}
+	if err != nil {
+		c.complain(err, status, "")
+		return
+	}
+	if c.MaxResponseBytes > 0 {
+		rd = &limitedReader{rd, c.MaxResponseBytes}
+	}
+	if r.Output != nil && r.Method != HEAD && status >= 200 && status < 300 {
+		var n int64
+		n, err = io.Copy(r.Output, rd)
+		r.Duration = c.now().Sub(r.Timestamp)
+		r.HasBody = n > 0
+		if err != nil {
+			c.complain(err, status, "")
+		}
+		return
+	}
+	//
+	// In streaming mode a successful response is decoded straight from the
+	// connection, without being buffered into RawText.
+	//
+	if r.Stream && r.ResultField == "" && r.Validate == nil && r.Method != HEAD && status >= 200 && status < 300 {
+		br := bufio.NewReader(rd)
+		_, perr := br.Peek(1)
+		r.HasBody = perr == nil
+		err = c.decodeStream(r, resp, br)
+		r.Duration = c.now().Sub(r.Timestamp)
+		if err != nil {
+			c.complain(err, status, "")
+			err = &DecodeError{Status: status, Err: err}
+		}
+		return
+	}
+	//
+	// The body is read into a pooled buffer, which is released once decoded;
+	// RawText gets its own copy, as do Validate and decoders other than the
+	// built-in JSON and XML, which may keep what they are given.
+	//
+	buf := getBuffer()
+	defer putBuffer(buf)
+	_, err = buf.ReadFrom(rd)
+	r.Duration = c.now().Sub(r.Timestamp)
+	r.RawText = buf.String()
+	r.HasBody = buf.Len() > 0
+	if err != nil {
+		// RawText holds whatever arrived before the error
+		c.complain(err, status, r.RawText)
+		return
+	}
+	data := buf.Bytes()
+	if r.Validate != nil || retainsData(c.bodyEncoding(resp)) {
+		data = copyBytes(data)
+	}
+	if r.Validate != nil && r.Method != HEAD && status >= 200 && status < 300 {
+		err = r.Validate(data)
+		if err != nil {
+			c.complain(err, status, r.RawText)
+			return
+		}
+	}
+	err = c.decode(r, resp, data)
+	if err != nil {
+		c.complain(err, status, r.RawText)
+		err = &DecodeError{Status: status, RawText: r.RawText, Err: err}
+		return
+	}
+	switch {
+	case status == http.StatusPreconditionFailed && r.IfMatch != "":
+		err = ErrPreconditionFailed
+	case (c.ErrorOnStatus || r.ErrorOnStatus) && (status < 200 || status >= 300):
+		err = c.statusError(r, status)
+	}
+	return
+}
+
+// DoStream executes a REST request like Do, but returns the server's response
+// with its body unread, rather than unmarshalling it into r.Result or r.Error.
+// The caller must close the response body.  Middleware is not applied.
+func (c *Client) DoStream(r *RequestResponse) (*http.Response, error) {
+	return c.send(r)
+}
+
+// prepare returns the URL, body and body content type of the HTTP request
+// described by r, setting r.SentURL.
+func (c *Client) prepare(r *RequestResponse) (u *url.URL, body []byte, contentType string, err error) {
 	//
 	// Create a URL object from the raw url string.  This will allow us to compose
 	// query parameters programmatically and be guaranteed of a well-formed URL.
 	//
-	u, err := url.Parse(r.Url)
+	rawurl, err := expandPath(r.Url, r.PathParams)
+	if err == nil {
+		u, err = c.resolve(rawurl)
+	}
 	if err != nil {
-		log.Println(err)
+		err = &RequestError{Phase: URLPhase, URL: r.Url, Err: err}
+		c.logf("%v", err)
 		return
 	}
 	//
-	// If we are making a GET request and the user populated the Params field, then
-	// add the params to the URL's querystring.
+	// If the user populated the Params, Query or QueryStruct fields, then add
+	// the params to the URL's querystring.
 	//
-	if r.Method == GET && r.Params != nil {
+	if r.Params != nil || r.Query != nil || r.QueryStruct != nil {
 		vals := u.Query()
 		for k, v := range r.Params {
 			vals.Set(k, v)
 		}
+		for k, vv := range r.Query {
+			for _, v := range vv {
+				vals.Add(k, v)
+			}
+		}
+		if r.QueryStruct != nil {
+			err = encodeQuery(vals, r.QueryStruct)
+			if err != nil {
+				err = &RequestError{Phase: URLPhase, URL: r.Url, Err: err}
+				c.logf("%v", err)
+				return
+			}
+		}
 		u.RawQuery = vals.Encode()
 	}
+	r.SentURL = u
 	//
-	// Create a Request object; if populated, Data field is JSON encoded as request
-	// body
+	// If populated, Data field is encoded as request body, unless it is
+	// a []byte or io.Reader to be sent verbatim; FormData is form encoded, and
+	// Multipart is streamed as multipart/form-data.
 	//
-	r.Timestamp = time.Now()
-	m := string(r.Method)
-	var req *http.Request
-	if r.Data == nil {
-		req, err = http.NewRequest(m, u.String(), nil)
-	} else {
-		var b []byte
-		b, err = json.Marshal(r.Data)
+	body, contentType, err = c.encodeBody(r)
+	if err != nil {
+		err = &RequestError{Phase: EncodePhase, URL: u.String(), Err: err}
+	}
+	r.RequestBody = nil
+	if err == nil && c.RecordRequestBody {
+		r.RequestBody = body
+	}
+	if err == nil && c.MaxRequestBytes > 0 && (int64(len(body)) > c.MaxRequestBytes || r.ContentLength > c.MaxRequestBytes) {
+		err = ErrRequestTooLarge
+	}
+	if err == nil && r.Compress && body != nil {
+		body, err = compress(body)
 		if err != nil {
-			log.Println(err)
-			return
+			err = &RequestError{Phase: EncodePhase, URL: u.String(), Err: err}
 		}
-		buf := bytes.NewBuffer(b)
-		req, err = http.NewRequest(m, u.String(), buf)
-		req.Header.Add("Content-Type", "application/json")
 	}
 	if err != nil {
-		log.Println(err)
+		c.logf("%v", err)
 		return
 	}
 	//
-	// If Accept header is unset, set it for JSON.
+	// Refuse to send Basic Auth credentials in the clear
 	//
-	if req.Header.Get("Accept") == "" {
-		req.Header.Add("Accept", "application/json")
+	if r.Userinfo != nil && r.BearerToken != "" {
+		err = errors.New("Cannot use both Userinfo and BearerToken")
+		return
 	}
-	//
-	// Set HTTP Basic authentication if userinfo is supplied
-	//
-	if r.Userinfo != nil {
-		if !c.UnsafeBasicAuth && u.Scheme != "https" {
-			err = errors.New("Unsafe to use HTTP Basic authentication without HTTPS")
+	if r.Userinfo != nil && r.AuthScheme == BasicAuth && !c.UnsafeBasicAuth && u.Scheme != "https" {
+		err = errors.New("Unsafe to use HTTP Basic authentication without HTTPS")
+		return
+	}
+	return
+}
+
+// BuildRequest returns the HTTP request which Do would send for r, without
+// sending it, e.g. to check how it is built.  Everything but the server is
+// taken into account, including PrepareRequest and the client's Signer,
+// except that no Idempotency-Key is generated.  The request's context is
+// r.Context, not bounded by r.Timeout.  A Multipart body is written as it is
+// read, so the caller must read or close the request's Body.
+func (c *Client) BuildRequest(r *RequestResponse) (*http.Request, error) {
+	u, body, contentType, err := c.prepare(r)
+	if err != nil {
+		return nil, err
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := c.newRequest(ctx, r, u, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if r.PrepareRequest != nil {
+		err = r.PrepareRequest(req)
+	}
+	if err == nil && c.Signer != nil {
+		err = c.Signer.Sign(req, body)
+	}
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	return req, nil
+}
+
+// send executes the HTTP request described by r, populating its Status and
+// Header fields.  On success the caller must close the response body, which
+// also releases any resources tied to r.Timeout.
+func (c *Client) send(r *RequestResponse) (resp *http.Response, err error) {
+	// Until a request is built from it, a Body which is an io.Closer is
+	// closed if Do fails, as http.Client.Do would.
+	built := false
+	defer func() {
+		if rc, ok := r.Body.(io.Closer); ok && !built {
+			rc.Close()
+		}
+	}()
+	u, body, contentType, err := c.prepare(r)
+	if err != nil {
+		return
+	}
+	if cb := c.CircuitBreaker; cb != nil {
+		if !cb.allow() {
+			err = ErrCircuitOpen
 			return
 		}
-		pwd, _ := r.Userinfo.Password()
-		req.SetBasicAuth(r.Userinfo.Username(), pwd)
+		defer func() {
+			cb.record(err == nil && resp.StatusCode < 500)
+		}()
+	}
+	if r.IdempotencyKey == "" && c.GenerateIdempotencyKeys && c.Retries > 0 &&
+		(r.Method == POST || r.Method == PATCH) {
+		r.IdempotencyKey, err = newUUID()
+		if err != nil {
+			return
+		}
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cancel := context.CancelFunc(func() {})
+	if r.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
 	}
 	//
-	// Execute the HTTP request
+	// Execute the HTTP request, retrying transient failures if so configured.
+	// The body is already buffered, so a fresh copy is sent on each attempt;
+	// multipart bodies are streamed, and so cannot be retried.
 	//
-	resp, err := c.HttpClient.Do(req)
+	var entry *CacheEntry
+	key := c.cacheKey(r, u.String())
+	if key != "" {
+		entry, _ = c.Cache.Get(key)
+	}
+	hc := c.HttpClient
+	if r.HttpClient != nil {
+		hc = r.HttpClient
+	}
+	r.Status, r.Header, r.RetryAfter, r.Links, r.FromCache = 0, nil, 0, nil, false
+	r.Timestamp = c.now()
+	gaveUp := false      // Whether retries were abandoned due to ctx
+	var sent http.Header // Headers of the last request sent
+	for attempt := 0; ; attempt++ {
+		var req *http.Request
+		built = true
+		req, err = c.newRequest(ctx, r, u, body, contentType)
+		if err != nil {
+			resp = nil
+			cancel()
+			c.logf("%v", err)
+			return
+		}
+		if entry != nil && entry.matches(req.Header) && req.Header.Get("If-None-Match") == "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		//
+		// From here on, req's body is closed if it isn't sent, as it would
+		// be by http.Client.Do, so that a streamed body's writer exits.
+		//
+		if c.RateLimiter != nil {
+			err = c.RateLimiter.Wait(ctx)
+			if err != nil {
+				closeBody(req)
+				resp = nil
+				break
+			}
+		}
+		if r.PrepareRequest != nil {
+			err = r.PrepareRequest(req)
+			if err != nil {
+				closeBody(req)
+				resp = nil
+				cancel()
+				c.logf("%v", err)
+				return
+			}
+		}
+		if c.OnRequest != nil {
+			c.OnRequest(req)
+		}
+		if c.Signer != nil {
+			err = c.Signer.Sign(req, body)
+			if err != nil {
+				closeBody(req)
+				resp = nil
+				cancel()
+				c.logf("%v", err)
+				return
+			}
+		}
+		sent = req.Header
+		resp, err = c.roundTrip(hc, req)
+		if err == nil && r.AuthScheme == DigestAuth {
+			resp, err = c.digestAuth(hc, r, req, resp)
+		}
+		if err == nil && r.AuthScheme == NTLMAuth {
+			resp, err = c.ntlmAuth(hc, r, req, resp)
+		}
+		if err != nil {
+			err = &RequestError{Phase: TransportPhase, URL: u.String(), Err: err}
+		}
+		if attempt >= c.Retries || !c.retryable(r, resp, err) {
+			break
+		}
+		wait := c.backoff(attempt)
+		lastErr := err
+		if resp != nil {
+			if d, ok := retryAfter(resp, c.now()); ok {
+				wait = d
+			}
+			lastErr = errors.New("Server returned status " + strconv.Itoa(resp.StatusCode))
+			c.setResponse(r, resp)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		//
+		// Give up at once if the context's deadline will pass before the
+		// next attempt is due.
+		//
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			err = errors.Join(context.DeadlineExceeded, lastErr)
+			gaveUp = true
+			break
+		}
+		if !sleep(ctx, wait) {
+			err = errors.Join(ctx.Err(), lastErr)
+			gaveUp = true
+			break
+		}
+	}
+	if err == nil && key != "" {
+		resp, err = c.cacheResponse(r, key, entry, sent, resp)
+	}
 	if err != nil {
-		complain(err, status, "")
+		// If the request was cancelled or timed out, report the context's
+		// error rather than the transport error it caused.
+		if ctx.Err() != nil && !gaveUp {
+			err = ctx.Err()
+		}
+		cancel()
+		resp = nil
+		r.Duration = c.now().Sub(r.Timestamp)
+		c.complain(err, r.Status, "")
 		return
 	}
-	defer resp.Body.Close()
-	status = resp.StatusCode
+	resp.Body = &cancelBody{resp.Body, cancel}
+	c.setResponse(r, resp)
+	return
+}
+
+// setResponse records the status and headers of resp in r.
+func (c *Client) setResponse(r *RequestResponse, resp *http.Response) {
 	r.Status = resp.StatusCode
-	var data []byte
-	data, err = ioutil.ReadAll(resp.Body)
+	r.Header = resp.Header
+	r.RetryAfter, _ = retryAfter(resp, c.now())
+	r.Links = parseLinks(resp.Header, r.SentURL)
+}
+
+// A cancelBody is a response body which releases its request's context when
+// closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// decode unmarshals the body of resp, already read into data, into r.Result
+// or r.Error as appropriate to its status.
+func (c *Client) decode(r *RequestResponse, resp *http.Response, data []byte) error {
+	// If server returned no data, don't bother trying to unmarshall it (which will fail anyways).
+	// Responses to HEAD requests never carry a body.
+	if len(data) == 0 || r.Method == HEAD {
+		return nil
+	}
+	// Nor if it isn't in a format we know how to decode, which is an error
+	// only if a Result is wanted.
+	enc := c.bodyEncoding(resp)
+	success := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if enc == nil && success {
+		return unsupportedType(r, resp)
+	}
+	if enc == nil {
+		// Error pages from proxies and gateways are often HTML or plain
+		// text; they are left in RawText.
+		return nil
+	}
+	if success {
+		if r.ResultField != "" {
+			if !isJSON(enc) {
+				return errors.New("ResultField can only be used with JSON responses")
+			}
+			var err error
+			data, err = extractField(data, r.ResultField)
+			if err != nil {
+				return err
+			}
+		}
+		return c.unmarshal(r, c.resultEncoding(enc), data, &r.Result, c.StrictDecode || c.DisallowUnknownFields)
+	}
+	if r.Error == nil && c.DefaultError != nil {
+		r.Error = reflect.New(reflect.TypeOf(c.DefaultError).Elem()).Interface()
+	}
+	return c.unmarshal(r, enc, data, &r.Error, c.StrictDecode)
+}
+
+// resolve parses rawurl, resolving it against the client's BaseURL if it is
+// relative.  Query parameters given in rawurl override those in BaseURL.
+func (c *Client) resolve(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || c.BaseURL == "" || u.IsAbs() {
+		return u, err
+	}
+	base, err := url.Parse(c.BaseURL)
 	if err != nil {
-		complain(err, status, string(data))
+		return nil, err
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	resolved := base.ResolveReference(u)
+	if base.RawQuery != "" {
+		q := base.Query()
+		for k, vv := range u.Query() {
+			q[k] = vv
+		}
+		resolved.RawQuery = q.Encode()
+	}
+	return resolved, nil
+}
+
+// expandPath replaces each {name} placeholder in rawurl with the value of
+// name in params, escaped as a path segment.  Every placeholder must have a
+// value, and every value a placeholder.
+func expandPath(rawurl string, params map[string]string) (string, error) {
+	if params == nil {
+		return rawurl, nil
+	}
+	var b strings.Builder
+	used := make(map[string]bool, len(params))
+	rest := rawurl
+	for {
+		i := strings.IndexAny(rest, "{}")
+		if i < 0 {
+			b.WriteString(rest)
+			break
+		}
+		if rest[i] == '}' {
+			return "", errors.New("Unmatched } in Url " + strconv.Quote(rawurl))
+		}
+		j := strings.IndexAny(rest[i+1:], "{}")
+		if j < 0 || rest[i+1+j] == '{' {
+			return "", errors.New("Unmatched { in Url " + strconv.Quote(rawurl))
+		}
+		name := rest[i+1 : i+1+j]
+		v, ok := params[name]
+		if !ok {
+			return "", errors.New("No PathParams value for {" + name + "} in Url " + strconv.Quote(rawurl))
+		}
+		used[name] = true
+		b.WriteString(rest[:i])
+		b.WriteString(url.PathEscape(v))
+		rest = rest[i+j+2:]
+	}
+	for name := range params {
+		if !used[name] {
+			return "", errors.New("No placeholder for PathParams " + strconv.Quote(name) + " in Url " + strconv.Quote(rawurl))
+		}
+	}
+	return b.String(), nil
+}
+
+// encodeBody returns the request body for r, and its content type.
+func (c *Client) encodeBody(r *RequestResponse) (body []byte, contentType string, err error) {
+	n := 0
+	for _, set := range []bool{r.Data != nil, r.FormData != nil, r.Multipart != nil || r.Files != nil, r.Body != nil} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		err = errors.New("Only one of Data, FormData, Multipart and Body may be used")
 		return
 	}
-	r.RawText = string(data)
-	// If server returned no data, don't bother trying to unmarshall it (which will fail anyways).
-	if r.RawText == "" {
+	if n > 0 && r.Method == TRACE {
+		err = errors.New("TRACE requests cannot have a body")
 		return
 	}
-	if status >= 200 && status < 300 {
-		err = c.unmarshal(data, &r.Result)
-	} else {
-		err = c.unmarshal(data, &r.Error)
+	if r.Multipart != nil {
+		err = r.Multipart.check()
+		if err != nil {
+			return
+		}
 	}
-	if err != nil {
-		log.Println(status)
-		log.Println(err)
-		log.Println(r.RawText)
-		log.Println(resp)
-		log.Println(resp.Request)
+	if r.Files != nil {
+		err = checkFiles(r.Files)
+		return
+	}
+	if r.Body != nil {
+		return
+	}
+	if r.FormData != nil {
+		body = []byte(r.FormData.Encode())
+		contentType = "application/x-www-form-urlencoded"
+		return
+	}
+	switch d := r.Data.(type) {
+	case nil:
+	case []byte:
+		body = d
+	case io.Reader:
+		body, err = ioutil.ReadAll(d)
+	default:
+		enc := c.encoding()
+		body, err = enc.Marshal(r.Data)
+		contentType = enc.ContentType()
+		if r.PatchType != "" {
+			if !isJSON(enc) {
+				err = errors.New("PatchType can only be used with JSON encoding")
+			}
+			contentType = string(r.PatchType)
+		}
 	}
 	return
 }
 
-// unmarshal parses the JSON-encoded data and stores the result in the value
-// pointed to by v.  If the data cannot be unmarshalled without error, v will be 
-// reassigned the value interface{}, and data unmarshalled into that.
-func (c *Client) unmarshal(data []byte, v interface{}) error {
-	err := json.Unmarshal(data, v)
-	if err == nil {
-		return nil
+// limitedReader reads from r, failing with ErrResponseTooLarge once more
+// than n bytes have been read.
+type limitedReader struct {
+	r io.Reader
+	n int64 // Bytes remaining
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
 	}
-	v = new(interface{})
-	return json.Unmarshal(data, v)
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n + int(l.n), ErrResponseTooLarge
+	}
+	return n, err
 }
 
-// complain prints detailed error messages to the log.
-func complain(err error, status int, rawtext string) {
-	_, file, line, ok := runtime.Caller(2)
-	if !ok {
-		file = "???"
-		line = 0
+// compress returns the gzip compressed form of body.
+func compress(body []byte) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	w := gzip.NewWriter(buf)
+	_, err := w.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return copyBytes(buf.Bytes()), nil
+}
+
+// decompress returns a reader for the body of resp, decompressing it if its
+// Content-Encoding is gzip or deflate.  Go's transport already does this
+// unless the request set its own Accept-Encoding header.
+func decompress(resp *http.Response) (io.Reader, error) {
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		// Deflate should be zlib wrapped, but some servers send raw
+		// deflate data; check for a zlib header to tell them apart.
+		br := bufio.NewReader(resp.Body)
+		b, err := br.Peek(2)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(b) == 2 && b[0]&0x0f == 8 && (uint(b[0])<<8|uint(b[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return resp.Body, nil
+}
+
+// newRequest creates an HTTP request for r, with body as its payload.
+func (c *Client) newRequest(ctx context.Context, r *RequestResponse, u *url.URL, body []byte, contentType string) (*http.Request, error) {
+	var buf io.Reader
+	switch {
+	case r.Files != nil:
+		buf, contentType = r.Multipart.withFiles(r.Files).stream()
+	case r.Multipart != nil:
+		buf, contentType = r.Multipart.stream()
+	case r.Body != nil:
+		buf = r.Body
+	case body != nil:
+		buf = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, string(r.Method), u.String(), buf)
+	if err != nil {
+		if rc, ok := buf.(io.Closer); ok {
+			rc.Close()
+		}
+		return nil, err
+	}
+	if r.Body != nil && r.ContentLength > 0 {
+		req.ContentLength = r.ContentLength
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if r.Compress && body != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	//
+	// If Accept header is unset, set it for the client's encoding.
+	//
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", c.encoding().ContentType())
+	}
+	ua := c.UserAgent
+	if ua == "" {
+		ua = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", ua)
+	//
+	// Apply caller-supplied headers, those given for this request taking
+	// precedence over the client's defaults.
+	//
+	overrideHeaders(req.Header, c.DefaultHeaders)
+	if r.Headers != nil {
+		overrideHeaders(req.Header, *r.Headers)
+	}
+	//
+	// Set HTTP Basic authentication if userinfo is supplied
+	//
+	if r.Userinfo != nil && r.AuthScheme == BasicAuth {
+		pwd, _ := r.Userinfo.Password()
+		req.SetBasicAuth(r.Userinfo.Username(), pwd)
+	}
+	if r.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.BearerToken)
+	} else if c.TokenSource != nil && r.Userinfo == nil && req.Header.Get("Authorization") == "" {
+		token, err := c.TokenSource.Token()
+		if err != nil {
+			closeBody(req)
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if r.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", r.IdempotencyKey)
+	}
+	if r.IfMatch != "" {
+		req.Header.Set("If-Match", r.IfMatch)
+	}
+	if r.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", r.IfNoneMatch)
+	}
+	if !r.IfModifiedSince.IsZero() {
+		req.Header.Set("If-Modified-Since", r.IfModifiedSince.UTC().Format(http.TimeFormat))
+	}
+	return req, nil
+}
+
+// closeBody closes the body of req, which is not to be sent.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// extractField returns the JSON-encoded value found in data at path, a
+// dot-separated series of object keys.
+func extractField(data []byte, path string) ([]byte, error) {
+	for _, key := range strings.Split(path, ".") {
+		var m map[string]json.RawMessage
+		err := json.Unmarshal(data, &m)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		data, ok = m[key]
+		if !ok {
+			return nil, errors.New("Field " + strconv.Quote(path) + " not found in response")
+		}
+	}
+	return data, nil
+}
+
+// overrideHeaders copies the headers in src to dst, replacing any values
+// already present in dst.
+func overrideHeaders(dst, src http.Header) {
+	for k, vv := range src {
+		dst.Del(k)
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
+
+// unmarshal parses the enc-encoded data and stores the result in the value
+// pointed to by v.  If the data cannot be unmarshalled into v, then unless
+// strict is set, or enc is made by NewEncoding and so has no generic form,
+// it is unmarshalled into r.Raw instead, and the error is ignored.
+func (c *Client) unmarshal(r *RequestResponse, enc Encoding, data []byte, v interface{}, strict bool) error {
+	err := enc.Unmarshal(data, target(v))
+	if _, custom := enc.(*customEncoding); err == nil || strict || custom {
+		return err
 	}
+	var raw interface{}
+	if enc.Unmarshal(data, &raw) != nil {
+		return err
+	}
+	r.Raw = raw
+	return nil
+}
+
+// target returns the value into which to unmarshal v, a pointer to Result
+// or Error.  Usually that is v itself, so that a JSON null sets the field to
+// nil, but a *json.RawMessage is unmarshalled into directly, so that it
+// captures the body exactly, null included.
+func target(v interface{}) interface{} {
+	if p, ok := v.(*interface{}); ok {
+		if rm, ok := (*p).(*json.RawMessage); ok {
+			return rm
+		}
+	}
+	return v
+}
+
+// logf writes a diagnostic message to the client's Logger.
+func (c *Client) logf(format string, v ...interface{}) {
+	l := c.Logger
+	if l == nil {
+		l = log.Default()
+	}
+	l.Printf(format, v...)
+}
+
+// complain prints detailed error messages to the log.
+func (c *Client) complain(err error, status int, rawtext string) {
+	file, line := caller()
 	lineNo := strconv.Itoa(line)
 	s := "Error executing REST request:\n"
 	s += "    --> Called from " + file + ":" + lineNo + "\n"
@@ -225,13 +1251,41 @@ func complain(err error, status int, rawtext string) {
 		s += "    --> Raw text of server response: " + rawtext + "\n"
 	}
 	s += "    --> " + err.Error()
-	log.Println(s)
+	c.logf("%s", s)
+}
+
+// caller returns the location from which this package was called.
+func caller() (file string, line int) {
+	_, self, _, _ := runtime.Caller(0)
+	dir := filepath.Dir(self)
+	for i := 2; ; i++ {
+		_, file, line, ok := runtime.Caller(i)
+		if !ok {
+			return "???", 0
+		}
+		if filepath.Dir(file) != dir || strings.HasSuffix(file, "_test.go") {
+			return file, line
+		}
+	}
 }
 
 var (
 	defaultClient = New()
 )
 
+// DefaultClient returns the client used by the package-level functions, such
+// as Do and Get, which may be configured like any other.
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+// SetDefaultClient replaces the client used by the package-level functions.
+// It is not safe to call while package-level requests are in flight, so
+// should be called during program initialization.
+func SetDefaultClient(c *Client) {
+	defaultClient = c
+}
+
 // Do executes a REST request using the default client.
 func Do(r *RequestResponse) (status int, err error) {
 	return defaultClient.Do(r)
@@ -4,19 +4,31 @@
 // slavery.
 
 //
-// The Neo4j Manual section numbers quoted herein refer to the manual for 
+// The Neo4j Manual section numbers quoted herein refer to the manual for
 // milestone release 1.8.M06.  http://docs.neo4j.org/chunked/milestone/
 
 package restclient
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/bmizerany/assert"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type structType struct {
@@ -56,7 +68,9 @@ func JsonError(w http.ResponseWriter, msg string, code int) {
 		http.Error(w, msg, code)
 		return
 	}
-	http.Error(w, string(blob), code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(blob)
 }
 
 func HandleGet(w http.ResponseWriter, req *http.Request) {
@@ -77,7 +91,7 @@ func HandleGet(w http.ResponseWriter, req *http.Request) {
 		JsonError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	req.Header.Add("content-type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(blob)
 }
 func HandlePost(w http.ResponseWriter, req *http.Request) {
@@ -113,7 +127,7 @@ func HandlePost(w http.ResponseWriter, req *http.Request) {
 		JsonError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	req.Header.Add("content-type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(blob)
 }
 
@@ -148,7 +162,7 @@ func HandlePut(w http.ResponseWriter, req *http.Request) {
 func TestGet(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
 	defer srv.Close()
-	// 
+	//
 	// Good request
 	//
 	client := New()
@@ -165,7 +179,7 @@ func TestGet(t *testing.T) {
 	}
 	assert.Equal(t, status, 200)
 	assert.Equal(t, r.Result, &barStruct)
-	// 
+	//
 	// Bad request
 	//
 	client = New()
@@ -224,3 +238,1250 @@ func TestPut(t *testing.T) {
 	// Server should return NO data
 	assert.Equal(t, r.RawText, "")
 }
+
+func HandleHeader(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("X-Request-Id", "abc123")
+	w.Header().Set("X-RateLimit-Remaining", "0")
+	JsonError(w, "Too many requests", 429)
+}
+
+func TestResponseHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleHeader))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: GET,
+		Error:  new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 429)
+	assert.Equal(t, r.Header.Get("X-Request-Id"), "abc123")
+	assert.Equal(t, r.Header.Get("X-RateLimit-Remaining"), "0")
+}
+
+func TestContextCancel(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	client := New()
+	r := RequestResponse{
+		Url:     "http://" + srv.Listener.Addr().String(),
+		Method:  GET,
+		Context: ctx,
+	}
+	_, err := client.Do(&r)
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
+
+func HandleMethod(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("X-Method", req.Method)
+	if req.Method == "HEAD" {
+		return
+	}
+	blob, err := json.Marshal(barStruct)
+	if err != nil {
+		JsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(blob)
+}
+
+func TestMethods(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleMethod))
+	defer srv.Close()
+	client := New()
+	for _, m := range []Method{PATCH, HEAD, OPTIONS, TRACE} {
+		r := RequestResponse{
+			Url:    "http://" + srv.Listener.Addr().String(),
+			Method: m,
+			Result: new(structType),
+		}
+		status, err := client.Do(&r)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, status, 200)
+		assert.Equal(t, r.Header.Get("X-Method"), string(m))
+		if m == HEAD {
+			assert.Equal(t, r.RawText, "")
+			assert.Equal(t, r.Result, new(structType))
+		} else {
+			assert.Equal(t, r.Result, &barStruct)
+		}
+	}
+}
+
+func TestTraceConnect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleMethod))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{Url: srv.URL, Method: TRACE, Data: &fooStruct}
+	_, err := client.Do(&r)
+	assert.NotEqual(t, err, nil)
+	r = RequestResponse{Url: srv.URL, Method: CONNECT, Result: new(structType)}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Header.Get("X-Method"), "CONNECT")
+	assert.Equal(t, r.Result, new(structType))
+}
+
+func TestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+	client := New()
+	r := RequestResponse{
+		Url:     "http://" + srv.Listener.Addr().String(),
+		Method:  GET,
+		Timeout: 50 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err := client.Do(&r)
+	assert.Equal(t, err, context.DeadlineExceeded)
+	assert.T(t, time.Since(start) < time.Second)
+}
+
+func TestLogger(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	url := "http://" + srv.Listener.Addr().String()
+	srv.Close()
+	var buf bytes.Buffer
+	client := New()
+	client.Logger = log.New(&buf, "", 0)
+	r := RequestResponse{
+		Url:    url,
+		Method: GET,
+	}
+	_, err := client.Do(&r)
+	assert.NotEqual(t, err, nil)
+	assert.T(t, bytes.Contains(buf.Bytes(), []byte("Error executing REST request")))
+}
+
+func TestBearerToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Authorization", req.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:         "http://" + srv.Listener.Addr().String(),
+		Method:      GET,
+		BearerToken: "s3cret",
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, r.Header.Get("X-Authorization"), "Bearer s3cret")
+	//
+	// Bearer token and Basic Auth are mutually exclusive
+	//
+	client.UnsafeBasicAuth = true
+	r.Userinfo = url.UserPassword("user", "pass")
+	_, err = client.Do(&r)
+	assert.NotEqual(t, err, nil)
+}
+
+func HandleForm(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		JsonError(w, "Bad content type", http.StatusBadRequest)
+		return
+	}
+	if req.PostFormValue("foo") != "bar" {
+		JsonError(w, "Bad form data", http.StatusBadRequest)
+		return
+	}
+	blob, _ := json.Marshal(barStruct)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(blob)
+}
+
+func TestFormData(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleForm))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:      "http://" + srv.Listener.Addr().String(),
+		Method:   POST,
+		FormData: url.Values{"foo": {"bar"}},
+		Result:   new(structType),
+		Error:    new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Result, &barStruct)
+	//
+	// Data and FormData are mutually exclusive
+	//
+	r.Data = fooStruct
+	_, err = client.Do(&r)
+	assert.NotEqual(t, err, nil)
+}
+
+func HandleEcho(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("X-Content-Type", req.Header.Get("Content-Type"))
+	io.Copy(w, req.Body)
+}
+
+func TestRawData(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleEcho))
+	defer srv.Close()
+	client := New()
+	h := http.Header{}
+	h.Set("Content-Type", "application/xml")
+	for _, d := range []interface{}{
+		[]byte("<foo>bar</foo>"),
+		strings.NewReader("<foo>bar</foo>"),
+	} {
+		r := RequestResponse{
+			Url:     "http://" + srv.Listener.Addr().String(),
+			Method:  POST,
+			Headers: &h,
+			Data:    d,
+		}
+		status, _ := client.Do(&r)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, r.RawText, "<foo>bar</foo>")
+		assert.Equal(t, r.Header.Get("X-Content-Type"), "application/xml")
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	client := New()
+	client.BaseURL = "http://foo.com/api?key=secret&v=1"
+	for raw, expected := range map[string]string{
+		"users":                  "http://foo.com/api/users?key=secret&v=1",
+		"users/1?v=2":            "http://foo.com/api/users/1?key=secret&v=2",
+		"/users":                 "http://foo.com/users?key=secret&v=1",
+		"https://bar.com/spam":   "https://bar.com/spam",
+		"https://bar.com/spam?a": "https://bar.com/spam?a",
+	} {
+		u, err := client.resolve(raw)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, u.String(), expected)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	client = New()
+	client.BaseURL = "http://" + srv.Listener.Addr().String()
+	r := RequestResponse{
+		Url:    "foo",
+		Method: GET,
+		Params: fooMap,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Result, &barStruct)
+	assert.Equal(t, r.SentURL.String(), client.BaseURL+"/foo?foo=bar")
+}
+
+func HandleHeaders(w http.ResponseWriter, req *http.Request) {
+	for k, vv := range req.Header {
+		for _, v := range vv {
+			w.Header().Add("X-Echo-"+k, v)
+		}
+	}
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleHeaders))
+	defer srv.Close()
+	client := New()
+	client.DefaultHeaders = http.Header{
+		"X-Api-Version":   {"2"},
+		"Accept-Language": {"en"},
+	}
+	h := http.Header{}
+	h.Set("X-Api-Version", "3")
+	r := RequestResponse{
+		Url:     "http://" + srv.Listener.Addr().String(),
+		Method:  GET,
+		Headers: &h,
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, r.Header["X-Echo-X-Api-Version"], []string{"3"})
+	assert.Equal(t, r.Header["X-Echo-Accept-Language"], []string{"en"})
+	assert.Equal(t, r.Header["X-Echo-Accept"], []string{"application/json"})
+}
+
+func TestUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	url := "http://" + srv.Listener.Addr().String()
+	srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	client.Retries = 1
+	client.Backoff = func(attempt int) time.Duration { return 0 }
+	r := RequestResponse{
+		Url:    url,
+		Method: GET,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, status, 0)
+	assert.Equal(t, r.Status, 0)
+}
+
+func HandleSession(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/login" {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret"})
+		return
+	}
+	c, err := req.Cookie("session")
+	if err != nil || c.Value != "s3cret" {
+		JsonError(w, "Not logged in", http.StatusUnauthorized)
+	}
+}
+
+func TestCookies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleSession))
+	defer srv.Close()
+	client := New()
+	err := client.EnableCookies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/login", "/me"} {
+		r := RequestResponse{
+			Url:    srv.URL + path,
+			Method: GET,
+		}
+		status, err := client.Do(&r)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, status, 200)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		JsonError(w, "Not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: GET,
+		Error:  new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 404)
+	assert.T(t, r.Duration >= 20*time.Millisecond)
+}
+
+func TestPostParams(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("dry_run") != "true" {
+			JsonError(w, "Missing query params", http.StatusBadRequest)
+			return
+		}
+		HandlePost(w, req)
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: POST,
+		Params: map[string]string{"dry_run": "true"},
+		Data:   fooStruct,
+		Result: new(structType),
+		Error:  new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200, r.RawText)
+	assert.Equal(t, r.Result, &barStruct)
+}
+
+func TestQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Query", req.URL.RawQuery)
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String() + "?tag=x",
+		Method: GET,
+		Params: fooMap,
+		Query:  url.Values{"tag": {"a", "b"}},
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, r.Header.Get("X-Query"), "foo=bar&tag=x&tag=a&tag=b")
+}
+
+func TestCompress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Encoding") != "gzip" {
+			JsonError(w, "Body not compressed", http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			JsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Body = zr
+		req.ContentLength = 1
+		HandlePost(w, req)
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:      "http://" + srv.Listener.Addr().String(),
+		Method:   POST,
+		Data:     fooStruct,
+		Compress: true,
+		Result:   new(structType),
+		Error:    new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200, r.RawText)
+	assert.Equal(t, r.Result, &barStruct)
+}
+
+func TestDecompress(t *testing.T) {
+	blob, _ := json.Marshal(barStruct)
+	encoders := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+	}
+	for name, enc := range encoders {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", name)
+			zw := enc(w)
+			zw.Write(blob)
+			zw.Close()
+		}))
+		client := New()
+		h := http.Header{}
+		h.Set("Accept-Encoding", name)
+		r := RequestResponse{
+			Url:     "http://" + srv.Listener.Addr().String(),
+			Method:  GET,
+			Headers: &h,
+			Result:  new(structType),
+		}
+		status, err := client.Do(&r)
+		srv.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, status, 200)
+		assert.Equal(t, r.RawText, string(blob))
+		assert.Equal(t, r.Result, &barStruct)
+	}
+}
+
+func TestResultField(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"item": {"Foo": 222, "Bar": "bar"}}, "meta": {}}`))
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{
+		Url:         srv.URL,
+		Method:      GET,
+		Result:      new(structType),
+		ResultField: "data.item",
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, r.Result, &barStruct)
+	r.ResultField = "data.missing"
+	_, err = client.Do(&r)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestDoStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:     srv.URL,
+		Method:  GET,
+		Params:  fooMap,
+		Result:  new(structType),
+		Timeout: time.Second,
+	}
+	resp, err := client.DoStream(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, r.Status, 200)
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	expected, _ := json.Marshal(barStruct)
+	assert.Equal(t, b, expected)
+	assert.Equal(t, r.Result, new(structType))
+	assert.Equal(t, r.RawText, "")
+}
+
+func TestConnectionReuse(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(HandleGet))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	client := New()
+	for i := 0; i < 10; i++ {
+		r := RequestResponse{
+			Url:    srv.URL,
+			Method: GET,
+			Params: fooMap,
+			Result: new(structType),
+		}
+		status, err := client.Do(&r)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, status, 200)
+	}
+	assert.Equal(t, atomic.LoadInt32(&conns), int32(1))
+}
+
+func TestAcceptOverride(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleHeaders))
+	defer srv.Close()
+	client := New()
+	h := http.Header{}
+	h.Set("Accept", "text/csv")
+	r := RequestResponse{
+		Url:     srv.URL,
+		Method:  GET,
+		Headers: &h,
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, r.Header["X-Echo-Accept"], []string{"text/csv"})
+	//
+	// An empty value suppresses the default entirely
+	//
+	h = http.Header{"Accept": {}}
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, len(r.Header["X-Echo-Accept"]), 0)
+}
+
+func TestContentTypeOverride(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleHeaders))
+	defer srv.Close()
+	client := New()
+	h := http.Header{}
+	h.Set("Content-Type", "application/vnd.foo+json")
+	r := RequestResponse{
+		Url:     srv.URL,
+		Method:  POST,
+		Headers: &h,
+		Data:    fooStruct,
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, r.Header["X-Echo-Content-Type"], []string{"application/vnd.foo+json"})
+}
+
+func TestUserAgent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleHeaders))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+	}
+	client.Do(&r)
+	assert.Equal(t, r.Header.Get("X-Echo-User-Agent"), DefaultUserAgent)
+	client.UserAgent = "myapp/2.0"
+	client.Do(&r)
+	assert.Equal(t, r.Header.Get("X-Echo-User-Agent"), "myapp/2.0")
+	h := http.Header{}
+	h.Set("User-Agent", "special/3.0")
+	r.Headers = &h
+	client.Do(&r)
+	assert.Equal(t, r.Header.Get("X-Echo-User-Agent"), "special/3.0")
+}
+
+func TestConditional(t *testing.T) {
+	modified := time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-Modified-Since") == modified.Format(http.TimeFormat) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if req.Header.Get("If-Match") != `"v2"` {
+			JsonError(w, "Resource has changed", http.StatusPreconditionFailed)
+		}
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:             srv.URL,
+		Method:          GET,
+		IfModifiedSince: modified.In(time.FixedZone("EST", -5*3600)),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 304)
+	r = RequestResponse{
+		Url:     srv.URL,
+		Method:  PUT,
+		Data:    fooStruct,
+		IfMatch: `"v1"`,
+		Error:   new(errorStruct),
+	}
+	status, err = client.Do(&r)
+	assert.Equal(t, status, 412)
+	assert.Equal(t, err, ErrPreconditionFailed)
+	r.IfMatch = `"v2"`
+	_, err = client.Do(&r)
+	assert.Equal(t, err, nil)
+}
+
+func TestDeleteWithBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method != "DELETE":
+			JsonError(w, "Bad method", http.StatusMethodNotAllowed)
+		case req.Header.Get("Content-Type") != "application/json":
+			JsonError(w, "Bad content type", http.StatusBadRequest)
+		case req.URL.Query().Get("refresh") != "true":
+			JsonError(w, "Missing query params", http.StatusBadRequest)
+		default:
+			HandlePost(w, req)
+		}
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: DELETE,
+		Params: map[string]string{"refresh": "true"},
+		Data:   fooStruct,
+		Result: new(structType),
+		Error:  new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200, r.RawText)
+	assert.Equal(t, r.Result, &barStruct)
+}
+
+func TestStatusChecks(t *testing.T) {
+	for _, c := range []struct {
+		status                                  int
+		success, redirect, clientErr, serverErr bool
+	}{
+		{0, false, false, false, false},
+		{199, false, false, false, false},
+		{200, true, false, false, false},
+		{299, true, false, false, false},
+		{300, false, true, false, false},
+		{399, false, true, false, false},
+		{400, false, false, true, false},
+		{499, false, false, true, false},
+		{500, false, false, false, true},
+		{599, false, false, false, true},
+		{600, false, false, false, false},
+	} {
+		r := RequestResponse{Status: c.status}
+		assert.Equal(t, r.IsSuccess(), c.success, c.status)
+		assert.Equal(t, r.IsRedirect(), c.redirect, c.status)
+		assert.Equal(t, r.IsClientError(), c.clientErr, c.status)
+		assert.Equal(t, r.IsServerError(), c.serverErr, c.status)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`"` + strings.Repeat("x", 1<<16) + `"`))
+	}))
+	defer srv.Close()
+	client := New()
+	client.MaxResponseBytes = 1000
+	var s string
+	r := RequestResponse{Url: srv.URL, Method: GET, Result: &s}
+	_, err := client.Do(&r)
+	assert.Equal(t, err, ErrResponseTooLarge)
+	assert.Equal(t, len(r.RawText), 1000)
+	r = RequestResponse{Url: srv.URL, Method: GET, Result: &s, Stream: true}
+	_, err = client.Do(&r)
+	assert.T(t, errors.Is(err, ErrResponseTooLarge), err)
+	client.MaxResponseBytes = 1<<16 + 2
+	r = RequestResponse{Url: srv.URL, Method: GET, Result: &s}
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(s), 1<<16)
+	//
+	// Request bodies
+	//
+	client.MaxRequestBytes = 10
+	r = RequestResponse{Url: srv.URL, Method: POST, Data: &fooStruct}
+	_, err = client.Do(&r)
+	assert.Equal(t, err, ErrRequestTooLarge)
+}
+
+func TestTruncatedBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "100")
+		w.Header().Set("X-Request-Id", "abc123")
+		w.Write([]byte(`{"Foo":`))
+	}))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{Url: srv.URL, Method: GET, Result: new(structType)}
+	status, err := client.Do(&r)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Status, 200)
+	assert.Equal(t, r.Header.Get("X-Request-Id"), "abc123")
+	assert.Equal(t, r.RawText, `{"Foo":`)
+}
+
+func TestBuildRequest(t *testing.T) {
+	client := New()
+	client.BaseURL = "http://foo.com/api"
+	client.DefaultHeaders = http.Header{"X-Client": {"spam"}}
+	r := RequestResponse{
+		Url:         "users",
+		Method:      POST,
+		Params:      fooMap,
+		Headers:     &http.Header{"X-Request": {"eggs"}},
+		BearerToken: "tok",
+		Data:        &fooStruct,
+	}
+	req, err := client.BuildRequest(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, req.Method, "POST")
+	assert.Equal(t, req.URL.String(), "http://foo.com/api/users?foo=bar")
+	assert.Equal(t, req.Header.Get("Content-Type"), "application/json")
+	assert.Equal(t, req.Header.Get("Authorization"), "Bearer tok")
+	assert.Equal(t, req.Header.Get("X-Client"), "spam")
+	assert.Equal(t, req.Header.Get("X-Request"), "eggs")
+	body, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, string(body), `{"Foo":111,"Bar":"foo"}`)
+	//
+	// Invalid requests fail to build
+	//
+	r.Userinfo = url.UserPassword("jtkirk", "Beam me up")
+	_, err = client.BuildRequest(&r)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestEmptyResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/none":
+			w.WriteHeader(http.StatusNoContent)
+		case "/null":
+			w.Write([]byte("null"))
+		}
+	}))
+	defer srv.Close()
+	client := New()
+	for _, path := range []string{"/none", "/empty"} {
+		for _, stream := range []bool{false, true} {
+			res := barStruct
+			r := RequestResponse{Url: srv.URL + path, Method: GET, Result: &res, Stream: stream}
+			_, err := client.Do(&r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, r.HasBody, false)
+			assert.Equal(t, r.RawText, "")
+			assert.Equal(t, r.Result, &barStruct)
+		}
+	}
+	r := RequestResponse{Url: srv.URL + "/null", Method: GET, Result: new(structType)}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, r.HasBody, true)
+	assert.Equal(t, r.Result, nil)
+}
+
+func TestConcurrentDo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: req.URL.Query().Get("n")})
+		if req.URL.Query().Get("n") == "13" {
+			JsonError(w, "Unlucky", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	var observed int32
+	client := New(
+		WithDefaultError(&errorStruct{}),
+		WithHeader("X-Foo", "bar"),
+		WithRetries(1, func(int) time.Duration { return 0 }),
+	)
+	client.EnableCookies()
+	client.Cache = NewMemoryCache()
+	client.CircuitBreaker = &CircuitBreaker{Threshold: 1000, Cooldown: time.Second}
+	client.RateLimiter = NewRateLimiter(10000)
+	client.TokenSource = NewTokenSource(func() (string, time.Time, error) {
+		return "tok", time.Time{}, nil
+	})
+	client.OnResponse = func(*RequestResponse) { atomic.AddInt32(&observed, 1) }
+	client.Use(func(r *RequestResponse, next Handler) (int, error) { return next(r) })
+	rs := make([]*RequestResponse, 50)
+	for i := range rs {
+		rs[i] = &RequestResponse{
+			Url:    srv.URL,
+			Method: GET,
+			Query:  url.Values{"foo": {"bar"}, "n": {strconv.Itoa(i % 20)}},
+			Result: new(structType),
+		}
+	}
+	errs := client.DoAll(context.Background(), rs, 10)
+	for i, r := range rs {
+		assert.Equal(t, errs[i], nil)
+		if i%20 == 13 {
+			assert.Equal(t, r.Status, 400)
+			assert.Equal(t, r.Error.(*errorStruct).Message, "Unlucky")
+		} else {
+			assert.Equal(t, r.Status, 200)
+			assert.Equal(t, r.Result, &barStruct)
+		}
+	}
+	assert.Equal(t, atomic.LoadInt32(&observed), int32(50))
+}
+
+func TestPatchType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleHeaders))
+	defer srv.Close()
+	client := New()
+	for pt, ct := range map[PatchType]string{
+		"":         "application/json",
+		MergePatch: "application/merge-patch+json",
+		JSONPatch:  "application/json-patch+json",
+	} {
+		r := RequestResponse{
+			Url:       srv.URL,
+			Method:    PATCH,
+			Data:      []map[string]string{{"op": "remove", "path": "/foo"}},
+			PatchType: pt,
+		}
+		_, err := client.Do(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, r.Header.Get("X-Echo-Content-Type"), ct)
+	}
+	client.Encoding = XML
+	_, err := client.Do(&RequestResponse{Url: srv.URL, Method: PATCH, Data: fooStruct, PatchType: MergePatch})
+	assert.NotEqual(t, err, nil)
+}
+
+func TestRecordRequestBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePost))
+	defer srv.Close()
+	client := New()
+	r := RequestResponse{Url: srv.URL, Method: POST, Data: &fooStruct, Compress: true}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(r.RequestBody), 0)
+	client.RecordRequestBody = true
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(r.RequestBody), `{"Foo":111,"Bar":"foo"}`)
+}
+
+func TestValidate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePost))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	var seen string
+	errDrift := errors.New("Upstream drifted")
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: POST,
+		Data:   &fooStruct,
+		Result: new(structType),
+		Stream: true,
+		Validate: func(raw []byte) error {
+			seen = string(raw)
+			return nil
+		},
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Result, &barStruct)
+	assert.T(t, strings.Contains(seen, `"Bar":"bar"`), seen)
+	//
+	// A failed validation is returned, and Result left untouched
+	//
+	r.Result = new(structType)
+	r.Validate = func(raw []byte) error { return errDrift }
+	status, err = client.Do(&r)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, err, errDrift)
+	assert.Equal(t, r.Result, new(structType))
+	assert.NotEqual(t, r.RawText, "")
+	//
+	// Error responses aren't validated
+	//
+	r.Data = &barStruct
+	r.Error = new(errorStruct)
+	status, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, status, 200)
+}
+
+func TestPathParams(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path = req.URL.EscapedPath()
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{
+		Url:    srv.URL + "/users/{id}/orders/{orderId}",
+		Method: GET,
+		PathParams: map[string]string{
+			"id":      "a/b c",
+			"orderId": "?x=1#y&%",
+		},
+		Params: fooMap,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, path, "/users/a%2Fb%20c/orders/%3Fx=1%23y&%25")
+	assert.Equal(t, r.SentURL.Query().Get("foo"), "bar")
+	//
+	// Mismatched placeholders fail before anything is sent
+	//
+	for _, c := range []struct {
+		url    string
+		params map[string]string
+	}{
+		{"/users/{id}", map[string]string{}},
+		{"/users/{id}", map[string]string{"id": "1", "extra": "2"}},
+		{"/users/{id", map[string]string{"id": "1"}},
+		{"/users/id}", map[string]string{"id": "1"}},
+		{"/users/{{id}}", map[string]string{"id": "1"}},
+	} {
+		path = ""
+		r := RequestResponse{Url: srv.URL + c.url, Method: GET, PathParams: c.params}
+		_, err := client.Do(&r)
+		var re *RequestError
+		assert.T(t, errors.As(err, &re), c.url)
+		assert.Equal(t, re.Phase, URLPhase)
+		assert.Equal(t, path, "")
+	}
+}
+
+func TestOutput(t *testing.T) {
+	body := strings.Repeat("id,name\n1,foo\n", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			JsonError(w, "Not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	client := New()
+	var buf bytes.Buffer
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Output: &buf,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Status, 200)
+	assert.Equal(t, r.Header.Get("Content-Type"), "text/csv")
+	assert.T(t, r.Duration > 0)
+	assert.T(t, r.HasBody)
+	assert.Equal(t, buf.String(), body)
+	assert.Equal(t, r.RawText, "")
+	assert.Equal(t, r.Result, new(structType))
+	//
+	// Error responses are decoded, not written
+	//
+	buf.Reset()
+	r.Url = srv.URL + "/missing"
+	r.Error = new(errorStruct)
+	status, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 404)
+	assert.Equal(t, buf.Len(), 0)
+	assert.Equal(t, r.Error.(*errorStruct).Message, "Not found")
+}
+
+func TestClone(t *testing.T) {
+	h := http.Header{"X-Foo": {"foo"}}
+	r := &RequestResponse{
+		Url:        "http://example.com/{id}",
+		Method:     POST,
+		Params:     map[string]string{"a": "1"},
+		PathParams: map[string]string{"id": "1"},
+		Query:      url.Values{"b": {"2"}},
+		FormData:   url.Values{"c": {"3"}},
+		Headers:    &h,
+		Result:     new(structType),
+	}
+	r2 := r.Clone()
+	assert.Equal(t, r2, r)
+	r2.Params["a"] = "x"
+	r2.PathParams["id"] = "x"
+	r2.Query.Add("b", "x")
+	r2.FormData.Set("c", "x")
+	r2.Headers.Set("X-Foo", "x")
+	r2.Method = GET
+	assert.Equal(t, r.Params["a"], "1")
+	assert.Equal(t, r.PathParams["id"], "1")
+	assert.Equal(t, r.Query["b"], []string{"2"})
+	assert.Equal(t, r.FormData.Get("c"), "3")
+	assert.Equal(t, r.Headers.Get("X-Foo"), "foo")
+	assert.Equal(t, r.Method, POST)
+	// Shallow
+	assert.T(t, r2.Result == r.Result)
+	assert.Equal(t, (&RequestResponse{}).Clone(), &RequestResponse{})
+}
+
+func TestPrepareRequest(t *testing.T) {
+	var got http.Header
+	var trailer string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header
+		ioutil.ReadAll(req.Body)
+		trailer = req.Trailer.Get("X-Checksum")
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Data:   "body",
+		PrepareRequest: func(req *http.Request) error {
+			req.Header["x-lower"] = []string{"yes"}
+			req.URL.RawQuery = "foo=bar"
+			req.Trailer = http.Header{"X-Checksum": {"abc"}}
+			req.ContentLength = -1
+			return nil
+		},
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Result, &barStruct)
+	assert.Equal(t, got.Get("X-Lower"), "yes")
+	assert.Equal(t, trailer, "abc")
+	req, err := client.BuildRequest(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, req.URL.RawQuery, "foo=bar")
+	assert.Equal(t, req.Header["x-lower"], []string{"yes"})
+	//
+	// An error aborts the request
+	//
+	got = nil
+	abort := errors.New("Abort")
+	r.PrepareRequest = func(req *http.Request) error { return abort }
+	_, err = client.Do(&r)
+	assert.Equal(t, err, abort)
+	assert.Equal(t, got, http.Header(nil))
+}
+
+// A closeRecorder is a reader which records whether it has been closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestBody(t *testing.T) {
+	var length int64
+	var te []string
+	var got []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		length, te = req.ContentLength, req.TransferEncoding
+		got, _ = ioutil.ReadAll(req.Body)
+		JsonError(w, "Service unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	client.Retries = 2
+	client.Backoff = noBackoff
+	data := `{"Foo":111,"Bar":"foo"}`
+	h := http.Header{"Content-Type": {"application/json"}}
+	r := RequestResponse{
+		Url:           srv.URL,
+		Method:        PUT,
+		Headers:       &h,
+		Body:          ioutil.NopCloser(strings.NewReader(data)),
+		ContentLength: int64(len(data)),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not retried, as the body is spent
+	assert.Equal(t, status, 503)
+	assert.Equal(t, length, int64(len(data)))
+	assert.Equal(t, len(te), 0)
+	assert.Equal(t, string(got), data)
+	//
+	// Without a length it is chunked
+	//
+	r.Body = io.MultiReader(strings.NewReader(data))
+	r.ContentLength = 0
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, length, int64(-1))
+	assert.Equal(t, te, []string{"chunked"})
+	assert.Equal(t, string(got), data)
+	//
+	// The length of a strings.Reader is known
+	//
+	r.Body = strings.NewReader(data)
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, length, int64(len(data)))
+	assert.Equal(t, len(te), 0)
+	//
+	// A Body which isn't sent is closed
+	//
+	for _, prep := range []func(*http.Request) error{
+		nil,
+		func(*http.Request) error { return errors.New("Abort") },
+	} {
+		body := &closeRecorder{Reader: strings.NewReader(data)}
+		r := RequestResponse{Url: srv.URL, Method: PUT, Body: body, PrepareRequest: prep}
+		if prep == nil {
+			r.Data = &fooStruct
+		}
+		_, err = client.Do(&r)
+		assert.NotEqual(t, err, nil)
+		assert.T(t, body.closed)
+	}
+	//
+	// Exclusive with Data, and subject to MaxRequestBytes
+	//
+	r.Data = &fooStruct
+	_, err = client.Do(&r)
+	assert.NotEqual(t, err, nil)
+	r.Data = nil
+	r.ContentLength = 1000
+	client.MaxRequestBytes = 100
+	_, err = client.Do(&r)
+	assert.Equal(t, err, ErrRequestTooLarge)
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ExponentialBackoff waits 100ms before the first retry, doubling the wait
+// for each subsequent attempt, without jitter or limit.
+func ExponentialBackoff(attempt int) time.Duration {
+	return 100 * time.Millisecond << uint(attempt)
+}
+
+// DefaultBackoff is the Backoff used when none is set: full jitter, from
+// 100ms, up to 30s.
+var DefaultBackoff = FullJitterBackoff(100*time.Millisecond, 30*time.Second)
+
+// FullJitterBackoff returns a Backoff which waits a random time between zero
+// and the exponential backoff - base doubled for each attempt, up to max.
+// Randomising the whole wait spreads out the retries of many clients which
+// failed together, so they don't hit a recovering server in lockstep.
+func FullJitterBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		return randDuration(0, exponential(base, max, attempt))
+	}
+}
+
+// DecorrelatedJitterBackoff returns a Backoff whose waits are each a random
+// time between base and three times the previous wait, up to max.  Waits
+// grow more gently than with FullJitterBackoff, but never fall below base.
+func DecorrelatedJitterBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		// Backoffs are stateless, so the chain of previous waits is drawn
+		// afresh, which is as random as remembering it.
+		d := base
+		for i := 0; i <= attempt && d < max; i++ {
+			hi := d * 3
+			if hi > max || hi < d {
+				hi = max
+			}
+			d = randDuration(base, hi)
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// exponential returns base doubled attempt times, up to max.
+func exponential(base, max time.Duration, attempt int) time.Duration {
+	if attempt >= 62 {
+		return max
+	}
+	d := base << uint(attempt)
+	if d > max || d>>uint(attempt) != base {
+		return max
+	}
+	return d
+}
+
+// randDuration returns a random duration between lo and hi inclusive.
+func randDuration(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + time.Duration(mrand.Int63n(int64(hi-lo)+1))
+}
+
+// now returns the current time, as per the client's Now.
+func (c *Client) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// backoff returns the time to wait before retrying after the given attempt.
+func (c *Client) backoff(attempt int) time.Duration {
+	if c.Backoff == nil {
+		return DefaultBackoff(attempt)
+	}
+	return c.Backoff(attempt)
+}
+
+// retryable reports whether request r, which produced resp and err, should
+// be attempted again.
+func (c *Client) retryable(r *RequestResponse, resp *http.Response, err error) bool {
+	if (r.Method == POST || r.Method == PATCH) && !c.RetryPost {
+		return false
+	}
+	if r.Multipart != nil || r.Body != nil {
+		return false
+	}
+	if err != nil {
+		return c.RetryableError == nil || c.RetryableError(err)
+	}
+	if c.RetryableStatus == nil {
+		return DefaultRetryableStatus(resp.StatusCode)
+	}
+	return c.RetryableStatus(resp.StatusCode)
+}
+
+// DefaultRetryableStatus reports whether status is one retried by default:
+// 429 Too Many Requests, or any 5xx.
+func DefaultRetryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// retryAfter parses the Retry-After header of a 429 or 503 response, which
+// may give either a number of seconds or an HTTP date, measured from now.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return 0, false
+	}
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			secs = 0
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(h)
+	if err != nil {
+		return 0, false
+	}
+	d := t.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// sleep pauses for d, returning false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,326 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"context"
+	"errors"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer returns a server which fails with status 503 for the first
+// failures requests, then succeeds.  The number of requests received is
+// counted in hits.
+func flakyServer(failures int32, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(hits, 1)
+		if n <= failures {
+			JsonError(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		HandlePost(w, req)
+	}))
+}
+
+func noBackoff(attempt int) time.Duration {
+	return 0
+}
+
+func TestRetry(t *testing.T) {
+	var hits int32
+	srv := flakyServer(2, &hits)
+	defer srv.Close()
+	client := New()
+	client.Retries = 3
+	client.Backoff = noBackoff
+	client.RetryPost = true
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: POST,
+		Data:   fooStruct,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, hits, int32(3))
+	assert.Equal(t, r.Result, &barStruct)
+}
+
+func TestRetryExhausted(t *testing.T) {
+	var hits int32
+	srv := flakyServer(10, &hits)
+	defer srv.Close()
+	client := New()
+	client.Retries = 2
+	client.Backoff = noBackoff
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: GET,
+		Error:  new(errorStruct),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 503)
+	assert.Equal(t, hits, int32(3))
+}
+
+func TestNoRetryPost(t *testing.T) {
+	var hits int32
+	srv := flakyServer(1, &hits)
+	defer srv.Close()
+	client := New()
+	client.Retries = 3
+	client.Backoff = noBackoff
+	r := RequestResponse{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: POST,
+		Data:   fooStruct,
+	}
+	status, _ := client.Do(&r)
+	assert.Equal(t, status, 503)
+	assert.Equal(t, hits, int32(1))
+}
+
+func TestRetryCancelled(t *testing.T) {
+	var hits int32
+	srv := flakyServer(10, &hits)
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	client.Retries = 5
+	client.Backoff = func(attempt int) time.Duration { return time.Hour }
+	r := RequestResponse{
+		Url:     "http://" + srv.Listener.Addr().String(),
+		Method:  GET,
+		Timeout: 100 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err := client.Do(&r)
+	assert.T(t, errors.Is(err, context.DeadlineExceeded))
+	assert.T(t, time.Since(start) < time.Second)
+	assert.Equal(t, hits, int32(1))
+}
+
+func TestRetryAfter(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			JsonError(w, "Slow down", http.StatusTooManyRequests)
+			return
+		}
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	client := New()
+	client.Retries = 1
+	client.Backoff = func(attempt int) time.Duration { return time.Hour }
+	r := RequestResponse{
+		Url:     "http://" + srv.Listener.Addr().String(),
+		Method:  GET,
+		Params:  fooMap,
+		Result:  new(structType),
+		Timeout: 5 * time.Second,
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, hits, int32(2))
+	assert.Equal(t, r.Result, &barStruct)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{},
+	}
+	_, ok := retryAfter(resp, time.Now())
+	assert.Equal(t, ok, false)
+	resp.Header.Set("Retry-After", "120")
+	d, ok := retryAfter(resp, time.Now())
+	assert.Equal(t, ok, true)
+	assert.Equal(t, d, 2*time.Minute)
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	d, ok = retryAfter(resp, time.Now())
+	assert.Equal(t, ok, true)
+	assert.T(t, d > 59*time.Minute && d <= time.Hour)
+	resp.Header.Set("Retry-After", "garbage")
+	_, ok = retryAfter(resp, time.Now())
+	assert.Equal(t, ok, false)
+	resp.StatusCode = http.StatusOK
+	resp.Header.Set("Retry-After", "120")
+	_, ok = retryAfter(resp, time.Now())
+	assert.Equal(t, ok, false)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var keys []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		keys = append(keys, req.Header.Get("Idempotency-Key"))
+		n := len(keys)
+		mu.Unlock()
+		if n == 1 {
+			JsonError(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		HandlePost(w, req)
+	}))
+	defer srv.Close()
+	client := New()
+	client.Retries = 1
+	client.Backoff = noBackoff
+	client.RetryPost = true
+	client.GenerateIdempotencyKeys = true
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: POST,
+		Data:   fooStruct,
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, len(keys), 2)
+	assert.Equal(t, len(r.IdempotencyKey), 36)
+	assert.Equal(t, keys[0], r.IdempotencyKey)
+	assert.Equal(t, keys[1], r.IdempotencyKey)
+}
+
+func TestRetryDeadline(t *testing.T) {
+	var hits int32
+	srv := flakyServer(100, &hits)
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	client.Retries = 5
+	client.Backoff = func(attempt int) time.Duration { return 100 * time.Millisecond }
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	r := RequestResponse{
+		Url:     srv.URL,
+		Method:  GET,
+		Context: ctx,
+	}
+	start := time.Now()
+	status, err := client.Do(&r)
+	elapsed := time.Since(start)
+	assert.T(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.T(t, strings.Contains(err.Error(), "503"), err)
+	assert.T(t, elapsed < 250*time.Millisecond, elapsed)
+	assert.Equal(t, hits, int32(3))
+	// The last response's status survives
+	assert.Equal(t, status, 503)
+	assert.Equal(t, r.Status, 503)
+}
+
+func TestRetryPredicates(t *testing.T) {
+	var hits int32
+	srv := flakyServer(10, &hits)
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	client.Retries = 2
+	client.Backoff = noBackoff
+	client.RetryableStatus = func(status int) bool { return status == 502 || status == 504 }
+	r := RequestResponse{Url: srv.URL, Method: GET}
+	status, _ := client.Do(&r)
+	assert.Equal(t, status, 503)
+	assert.Equal(t, hits, int32(1))
+	//
+	// Errors
+	//
+	var errs int32
+	client.RetryableError = func(err error) bool {
+		atomic.AddInt32(&errs, 1)
+		return false
+	}
+	_, err := client.Do(&RequestResponse{Url: "http://127.0.0.1:1/", Method: GET})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, errs, int32(1))
+	assert.T(t, DefaultRetryableStatus(429))
+	assert.T(t, !DefaultRetryableStatus(409))
+}
+
+func TestJitterBackoff(t *testing.T) {
+	base, max := 100*time.Millisecond, 2*time.Second
+	full := FullJitterBackoff(base, max)
+	decorrelated := DecorrelatedJitterBackoff(base, max)
+	seen := map[time.Duration]bool{}
+	for attempt := 0; attempt < 70; attempt++ {
+		limit := max
+		if attempt < 4 {
+			limit = base << uint(attempt)
+		}
+		for i := 0; i < 20; i++ {
+			d := full(attempt)
+			assert.T(t, d >= 0 && d <= limit, attempt, d)
+			seen[d] = true
+			d = decorrelated(attempt)
+			assert.T(t, d >= base && d <= max, attempt, d)
+		}
+	}
+	// Waits are spread out, not synchronised
+	assert.T(t, len(seen) > 100, len(seen))
+	assert.T(t, DefaultBackoff(0) <= 100*time.Millisecond)
+	assert.Equal(t, exponential(time.Second, time.Hour, 1000), time.Hour)
+}
+
+func TestClientNow(t *testing.T) {
+	t0 := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// Years away by the real clock, but due now by the client's
+			w.Header().Set("Retry-After", t0.Format(http.TimeFormat))
+			JsonError(w, "Slow down", http.StatusTooManyRequests)
+			return
+		}
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	var calls int32
+	client := New()
+	client.Retries = 1
+	client.Now = func() time.Time {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return t0
+		}
+		return t0.Add(5 * time.Second)
+	}
+	r := RequestResponse{
+		Url:     srv.URL,
+		Method:  GET,
+		Params:  fooMap,
+		Timeout: 5 * time.Second,
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, hits, int32(2))
+	assert.Equal(t, r.Timestamp, t0)
+	assert.Equal(t, r.Duration, 5*time.Second)
+}
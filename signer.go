@@ -0,0 +1,19 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"net/http"
+)
+
+// A Signer signs outgoing requests, typically by computing an HMAC over the
+// method, URL and body and adding it as a header.  Sign is called for each
+// attempt, after all headers have been set and just before the request is
+// sent.  Body holds the exact bytes to be sent, after any compression; it is
+// nil for Multipart and Body requests, whose bodies are streamed.
+type Signer interface {
+	Sign(req *http.Request, body []byte) error
+}
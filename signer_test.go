@@ -0,0 +1,56 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var hmacKey = []byte("s3cret")
+
+func signature(method, uri string, body []byte) string {
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write([]byte(method + "\n" + uri + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type hmacSigner struct{}
+
+func (hmacSigner) Sign(req *http.Request, body []byte) error {
+	req.Header.Set("X-Signature", signature(req.Method, req.URL.RequestURI(), body))
+	return nil
+}
+
+func TestSigner(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if req.Header.Get("X-Signature") != signature(req.Method, req.URL.RequestURI(), body) {
+			JsonError(w, "Bad signature", http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	client := New()
+	client.Signer = hmacSigner{}
+	r := RequestResponse{
+		Url:    srv.URL + "/foo",
+		Method: POST,
+		Params: fooMap,
+		Data:   fooStruct,
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+}
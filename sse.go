@@ -0,0 +1,107 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Event is a Server-Sent Event, as received from a text/event-stream.
+type Event struct {
+	ID    string        // Last event ID set by the stream
+	Type  string        // Event type; "message" if the stream set none
+	Data  string        // Data lines, joined by newlines
+	Retry time.Duration // Reconnection time requested by the stream, if any
+}
+
+// DoStreamEvents executes r, which should return a text/event-stream, and
+// calls handler with each event as it arrives, until the stream ends,
+// handler returns an error, or r.Context is done.  The Accept header
+// defaults to text/event-stream.  A non-2xx response is returned as a
+// *StatusError, with r.Error left unset.
+func (c *Client) DoStreamEvents(r *RequestResponse, handler func(Event) error) error {
+	h := http.Header{}
+	if r.Headers != nil {
+		h = r.Headers.Clone()
+	}
+	if h.Get("Accept") == "" {
+		h.Set("Accept", "text/event-stream")
+	}
+	r.Headers = &h
+	resp, err := c.DoStream(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{Status: resp.StatusCode}
+	}
+	err = readEvents(resp.Body, handler)
+	if err != nil && r.Context != nil && r.Context.Err() != nil {
+		return r.Context.Err()
+	}
+	return err
+}
+
+// readEvents parses the event stream read from rd, as per the HTML
+// specification, calling handler with each event.
+func readEvents(rd io.Reader, handler func(Event) error) error {
+	br := bufio.NewReader(rd)
+	var ev Event
+	var data []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF {
+				// An incomplete event at the end of the stream is discarded
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			if data != nil {
+				ev.Data = strings.Join(data, "\n")
+				if ev.Type == "" {
+					ev.Type = "message"
+				}
+				err := handler(ev)
+				if err != nil {
+					return err
+				}
+			}
+			ev = Event{ID: ev.ID}
+			data = nil
+			continue
+		}
+		if line[0] == ':' {
+			// Comment
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			ev.Type = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			ev.ID = value
+		case "retry":
+			ms, err := strconv.Atoi(value)
+			if err == nil {
+				ev.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
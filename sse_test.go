@@ -0,0 +1,59 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"errors"
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func HandleEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Accept") != "text/event-stream" {
+		JsonError(w, "Bad Accept header", http.StatusNotAcceptable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Write([]byte(": comment\n\ndata: first\n\n"))
+	w.(http.Flusher).Flush()
+	w.Write([]byte("event: update\r\nid: 2\r\nretry: 500\r\ndata: {\"n\":1}\r\ndata:second line\r\n\r\n"))
+	w.Write([]byte("data: third\n\ndata: incomplete"))
+}
+
+func TestDoStreamEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleEvents))
+	defer srv.Close()
+	client := New()
+	var events []Event
+	r := RequestResponse{Url: srv.URL, Method: GET}
+	err := client.DoStreamEvents(&r, func(ev Event) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, events, []Event{
+		{Type: "message", Data: "first"},
+		{ID: "2", Type: "update", Data: "{\"n\":1}\nsecond line", Retry: 500 * time.Millisecond},
+		{ID: "2", Type: "message", Data: "third"},
+	})
+	//
+	// The handler can stop the stream
+	//
+	stop := errors.New("stop")
+	n := 0
+	r = RequestResponse{Url: srv.URL, Method: GET}
+	err = client.DoStreamEvents(&r, func(ev Event) error {
+		n++
+		return stop
+	})
+	assert.Equal(t, err, stop)
+	assert.Equal(t, n, 1)
+}
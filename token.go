@@ -0,0 +1,60 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"sync"
+	"time"
+)
+
+// A TokenSource supplies the OAuth2 access tokens sent as Bearer tokens by
+// a Client.  Token is called for each HTTP request, so implementations
+// should cache tokens until they expire; see NewTokenSource.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// tokenExpiryDelta is how long before its expiry a token is refreshed, so
+// that it doesn't expire in flight.
+const tokenExpiryDelta = 10 * time.Second
+
+// NewTokenSource returns a TokenSource which calls fetch for a token and its
+// expiry time, and reuses it until shortly before it expires.  A zero expiry
+// means the token never expires.  It is safe for concurrent use; concurrent
+// requests for an expired token wait on a single call to fetch.  To use an
+// oauth2.TokenSource:
+//
+//	NewTokenSource(func() (string, time.Time, error) {
+//		t, err := ts.Token()
+//		if err != nil {
+//			return "", time.Time{}, err
+//		}
+//		return t.AccessToken, t.Expiry, nil
+//	})
+func NewTokenSource(fetch func() (token string, expiry time.Time, err error)) TokenSource {
+	return &cachedTokenSource{fetch: fetch}
+}
+
+type cachedTokenSource struct {
+	mu     sync.Mutex
+	fetch  func() (string, time.Time, error)
+	token  string
+	expiry time.Time
+}
+
+func (s *cachedTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && (s.expiry.IsZero() || time.Now().Add(tokenExpiryDelta).Before(s.expiry)) {
+		return s.token, nil
+	}
+	token, expiry, err := s.fetch()
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expiry = token, expiry
+	return token, nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Authorization", req.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+	fetches := 0
+	expiry := time.Now().Add(time.Hour)
+	client := New()
+	client.TokenSource = NewTokenSource(func() (string, time.Time, error) {
+		fetches++
+		return "tok" + strconv.Itoa(fetches), expiry, nil
+	})
+	for i := 0; i < 2; i++ {
+		r := RequestResponse{Url: srv.URL, Method: GET}
+		_, err := client.Do(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, r.Header.Get("X-Authorization"), "Bearer tok1")
+	}
+	assert.Equal(t, fetches, 1)
+	//
+	// A token about to expire is refreshed
+	//
+	fetches = 0
+	expiry = time.Now().Add(time.Second)
+	client.TokenSource = NewTokenSource(func() (string, time.Time, error) {
+		fetches++
+		return "tok" + strconv.Itoa(fetches), expiry, nil
+	})
+	for i := 1; i <= 2; i++ {
+		r := RequestResponse{Url: srv.URL, Method: GET}
+		client.Do(&r)
+		assert.Equal(t, r.Header.Get("X-Authorization"), "Bearer tok"+strconv.Itoa(i))
+	}
+	//
+	// A request's own credentials take precedence
+	//
+	r := RequestResponse{Url: srv.URL, Method: GET, BearerToken: "mine"}
+	client.Do(&r)
+	assert.Equal(t, r.Header.Get("X-Authorization"), "Bearer mine")
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"net/http"
+)
+
+// A Tracer records a span for each HTTP request sent by a Client.  This
+// package doesn't depend on any tracing library; an adapter for
+// OpenTelemetry, for instance, would start a child span of the request's
+// context, record the method and URL, and inject the span context into the
+// request headers with the configured propagator.
+type Tracer interface {
+	// Start begins a span for req, returning the request to send in its
+	// place, typically carrying the span in its context and headers.
+	Start(req *http.Request) (*http.Request, Span)
+}
+
+// A Span is a single traced HTTP request.
+type Span interface {
+	// End completes the span, recording the response status, or the error
+	// which prevented a response from being received.
+	End(status int, err error)
+}
+
+// roundTrip sends req with hc, tracing it if the client has a Tracer.
+func (c *Client) roundTrip(hc *http.Client, req *http.Request) (*http.Response, error) {
+	if c.Tracer == nil {
+		return hc.Do(req)
+	}
+	req, span := c.Tracer.Start(req)
+	resp, err := hc.Do(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	span.End(status, err)
+	return resp, err
+}
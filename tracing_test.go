@@ -0,0 +1,62 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"github.com/bmizerany/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+type testSpan struct {
+	method string
+	status int
+	err    error
+}
+
+func (t *testTracer) Start(req *http.Request) (*http.Request, Span) {
+	s := &testSpan{method: req.Method}
+	t.spans = append(t.spans, s)
+	req.Header.Set("Traceparent", "00-trace-span-01")
+	return req, s
+}
+
+func (s *testSpan) End(status int, err error) {
+	s.status = status
+	s.err = err
+}
+
+func TestTracer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Traceparent") == "" {
+			JsonError(w, "Missing trace context", http.StatusBadRequest)
+			return
+		}
+		HandleGet(w, req)
+	}))
+	defer srv.Close()
+	tracer := new(testTracer)
+	client := New()
+	client.Tracer = tracer
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Params: fooMap,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, len(tracer.spans), 1)
+	assert.Equal(t, *tracer.spans[0], testSpan{method: "GET", status: 200})
+}
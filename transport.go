@@ -0,0 +1,206 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// transport returns the *http.Transport used by the client's HttpClient,
+// installing a copy of http.DefaultTransport if it has none, so that it may
+// be configured without affecting other clients.
+func (c *Client) transport() (*http.Transport, error) {
+	switch t := c.HttpClient.Transport.(type) {
+	case nil:
+		t2 := http.DefaultTransport.(*http.Transport).Clone()
+		c.HttpClient.Transport = t2
+		return t2, nil
+	case *http.Transport:
+		return t, nil
+	}
+	return nil, errors.New("HttpClient.Transport is not an *http.Transport")
+}
+
+// tlsConfig returns the TLS configuration of the client's transport,
+// creating an empty one if necessary.
+func (c *Client) tlsConfig() (*tls.Config, error) {
+	t, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = new(tls.Config)
+	}
+	return t.TLSClientConfig, nil
+}
+
+// SetTLSConfig sets the TLS configuration used by the client's transport,
+// leaving its other settings untouched.
+func (c *Client) SetTLSConfig(cfg *tls.Config) error {
+	t, err := c.transport()
+	if err != nil {
+		return err
+	}
+	t.TLSClientConfig = cfg
+	return nil
+}
+
+// LoadClientCertificate loads a PEM encoded certificate and private key from
+// the given files, for the client to present to servers requiring mutual
+// TLS.
+func (c *Client) LoadClientCertificate(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cfg, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Certificates = append(cfg.Certificates, cert)
+	return nil
+}
+
+// AddRootCAs adds PEM encoded CA certificates to those the client trusts to
+// verify servers.  Once any are added, the system's CAs are no longer
+// trusted.
+func (c *Client) AddRootCAs(pemCerts []byte) error {
+	cfg, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.RootCAs == nil {
+		cfg.RootCAs = x509.NewCertPool()
+	}
+	if !cfg.RootCAs.AppendCertsFromPEM(pemCerts) {
+		return errors.New("No certificates found in PEM data")
+	}
+	return nil
+}
+
+// SetProxy routes the client's requests through the HTTP proxy at proxyURL.
+func (c *Client) SetProxy(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
+	}
+	t, err := c.transport()
+	if err != nil {
+		return err
+	}
+	t.Proxy = http.ProxyURL(u)
+	return nil
+}
+
+// SetProxyFromEnvironment routes the client's requests through the proxy
+// given by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+// This is the default for a client whose HttpClient has no Transport.
+func (c *Client) SetProxyFromEnvironment() error {
+	t, err := c.transport()
+	if err != nil {
+		return err
+	}
+	t.Proxy = http.ProxyFromEnvironment
+	return nil
+}
+
+// SetConnectionPool tunes the pool of idle keep-alive connections kept by
+// the client's transport: at most maxIdle in all and maxIdlePerHost to any
+// one host, each closed after idleTimeout unused.  Zero means no limit,
+// except for maxIdlePerHost, where it means http.DefaultMaxIdleConnsPerHost.
+// The defaults are those of http.DefaultTransport - 100, 2 and 90 seconds -
+// and a client making many concurrent requests to one host should raise
+// maxIdlePerHost, so connections are reused rather than exhausting ports.
+func (c *Client) SetConnectionPool(maxIdle, maxIdlePerHost int, idleTimeout time.Duration) error {
+	t, err := c.transport()
+	if err != nil {
+		return err
+	}
+	t.MaxIdleConns = maxIdle
+	t.MaxIdleConnsPerHost = maxIdlePerHost
+	t.IdleConnTimeout = idleTimeout
+	return nil
+}
+
+// SetForceHTTP2 controls whether the client's transport attempts HTTP/2
+// with servers supporting it.  It does by default, but only so long as its
+// TLS configuration and dialer are not customized; after SetTLSConfig and
+// the like, HTTP/2 must be forced.
+func (c *Client) SetForceHTTP2(force bool) error {
+	t, err := c.transport()
+	if err != nil {
+		return err
+	}
+	t.ForceAttemptHTTP2 = force
+	return nil
+}
+
+// SetDialTimeout limits how long the client's transport waits to connect
+// to a server, 30 seconds by default, so that unreachable hosts fail fast.
+// This, SetTLSHandshakeTimeout and SetResponseHeaderTimeout limit each
+// attempt at a request, and a request failing on one is retried as usual;
+// a request's Timeout and Context still bound it overall, including retries.
+func (c *Client) SetDialTimeout(d time.Duration) error {
+	t, err := c.transport()
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: d, KeepAlive: 30 * time.Second}
+	t.DialContext = dialer.DialContext
+	return nil
+}
+
+// SetTLSHandshakeTimeout limits how long the client's transport waits for a
+// TLS handshake, 10 seconds by default.  Zero means no limit.
+func (c *Client) SetTLSHandshakeTimeout(d time.Duration) error {
+	t, err := c.transport()
+	if err != nil {
+		return err
+	}
+	t.TLSHandshakeTimeout = d
+	return nil
+}
+
+// SetResponseHeaderTimeout limits how long the client's transport waits for
+// a server's response headers once a request has been written, which by
+// default it does indefinitely.  Reading the body is not limited.
+func (c *Client) SetResponseHeaderTimeout(d time.Duration) error {
+	t, err := c.transport()
+	if err != nil {
+		return err
+	}
+	t.ResponseHeaderTimeout = d
+	return nil
+}
+
+// SetRedirectPolicy controls how the client follows redirects.  At most max
+// redirects are followed; if max is 0 none are, and the 3xx response itself
+// is returned, so its Location header can be read.  Go drops the
+// Authorization header when redirected to another host; if preserveAuth is
+// set, it is sent on regardless.
+func (c *Client) SetRedirectPolicy(max int, preserveAuth bool) {
+	c.HttpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if max == 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > max {
+			return errors.New("Stopped after " + strconv.Itoa(max) + " redirects")
+		}
+		if preserveAuth {
+			if auth := via[0].Header.Get("Authorization"); auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+		}
+		return nil
+	}
+}
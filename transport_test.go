@@ -0,0 +1,224 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package restclient
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"github.com/bmizerany/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddRootCAs(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{
+		Url:    srv.URL,
+		Method: GET,
+		Params: fooMap,
+	}
+	//
+	// The test server's certificate is not trusted by default
+	//
+	_, err := client.Do(&r)
+	assert.NotEqual(t, err, nil)
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	err = client.AddRootCAs(pemCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.NotEqual(t, client.AddRootCAs([]byte("garbage")), nil)
+}
+
+func TestSetTLSConfig(t *testing.T) {
+	client := New()
+	tr := &http.Transport{MaxIdleConns: 7}
+	client.HttpClient.Transport = tr
+	cfg := &tls.Config{ServerName: "example.com"}
+	err := client.SetTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tr.TLSClientConfig, cfg)
+	assert.Equal(t, tr.MaxIdleConns, 7)
+	client.HttpClient.Transport = http.NewFileTransport(http.Dir("."))
+	assert.NotEqual(t, client.SetTLSConfig(cfg), nil)
+}
+
+func TestSetProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Host != "foo.invalid" {
+			JsonError(w, "Not a proxy request", http.StatusBadRequest)
+			return
+		}
+		HandleGet(w, req)
+	}))
+	defer proxy.Close()
+	client := New()
+	err := client.SetProxy(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := RequestResponse{
+		Url:    "http://foo.invalid/bar",
+		Method: GET,
+		Params: fooMap,
+		Result: new(structType),
+	}
+	status, err := client.Do(&r)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Result, &barStruct)
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Authorization", req.Header.Get("Authorization"))
+	}))
+	defer target.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Redirect via a different hostname for the same address
+		http.Redirect(w, req, strings.Replace(target.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	r := RequestResponse{
+		Url:         srv.URL,
+		Method:      GET,
+		BearerToken: "tok",
+	}
+	//
+	// Default policy follows the redirect, dropping Authorization
+	//
+	status, _ := client.Do(&r)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Header.Get("X-Authorization"), "")
+	client.SetRedirectPolicy(5, true)
+	status, _ = client.Do(&r)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, r.Header.Get("X-Authorization"), "Bearer tok")
+	//
+	// Not following redirects returns the redirect itself
+	//
+	client.SetRedirectPolicy(0, false)
+	status, _ = client.Do(&r)
+	assert.Equal(t, status, 302)
+	assert.NotEqual(t, r.Header.Get("Location"), "")
+}
+
+func TestConnectionTuning(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Proto", req.Proto)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	client := New()
+	err := client.SetConnectionPool(50, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := client.HttpClient.Transport.(*http.Transport)
+	assert.Equal(t, tr.MaxIdleConns, 50)
+	assert.Equal(t, tr.MaxIdleConnsPerHost, 10)
+	assert.Equal(t, tr.IdleConnTimeout, time.Minute)
+	//
+	// A custom TLS configuration disables HTTP/2 unless it is forced
+	//
+	cfg := srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	cfg.NextProtos = nil
+	client.SetTLSConfig(cfg)
+	err = client.SetForceHTTP2(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := RequestResponse{Url: srv.URL, Method: GET}
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, r.Header.Get("X-Proto"), "HTTP/2.0")
+}
+
+func TestRequestHttpClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	m := NewMockTransport()
+	m.Handle(GET, "/*", MockResponse{Body: `{"Foo":333,"Bar":"mock"}`})
+	client := New()
+	var res structType
+	r := RequestResponse{
+		Url:        srv.URL,
+		Method:     GET,
+		Result:     &res,
+		HttpClient: &http.Client{Transport: m},
+	}
+	_, err := client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res, structType{333, "mock"})
+	assert.Equal(t, len(m.Requests()), 1)
+	//
+	// The client's own HttpClient is used otherwise
+	//
+	r = RequestResponse{Url: srv.URL, Method: GET, Params: fooMap, Result: &res}
+	_, err = client.Do(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res, barStruct)
+	assert.Equal(t, len(m.Requests()), 1)
+}
+
+func TestTransportTimeouts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+	client := New()
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	for _, err := range []error{
+		client.SetDialTimeout(time.Second),
+		client.SetTLSHandshakeTimeout(time.Second),
+		client.SetResponseHeaderTimeout(50 * time.Millisecond),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tr := client.HttpClient.Transport.(*http.Transport)
+	assert.Equal(t, tr.TLSHandshakeTimeout, time.Second)
+	start := time.Now()
+	_, err := client.Do(&RequestResponse{Url: srv.URL, Method: GET})
+	assert.NotEqual(t, err, nil)
+	assert.T(t, time.Since(start) < 200*time.Millisecond)
+	//
+	// The dialer still connects
+	//
+	client.SetResponseHeaderTimeout(0)
+	status, err := client.Do(&RequestResponse{Url: srv.URL, Method: GET})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, 200)
+}